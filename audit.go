@@ -0,0 +1,77 @@
+package http
+
+import (
+	"net/http"
+	"time"
+)
+
+// AuditBodyLimit caps the number of body bytes kept in an AuditRecord, for both request and response.
+var AuditBodyLimit = 64 << 10
+
+// AuditRecord is a structured snapshot of one request/response exchange.
+type AuditRecord struct {
+	Method         string
+	URL            string
+	RequestHeader  http.Header
+	RequestBody    []byte
+	StatusCode     int
+	Status         string
+	ResponseHeader http.Header
+	ResponseBody   []byte
+	// Span is the correlation identifier set with Request.SetSpan.
+	Span string
+	// Truncated is true if either body was cut to AuditBodyLimit.
+	Truncated bool
+	StartAt   time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// WithAudit passes an AuditRecord of the request to sink once the response is received.
+// Bodies are captured through the repeatable readers, so they can still be consumed normally afterwards.
+func WithAudit(sink func(AuditRecord)) Option {
+	return WithMiddleware(AuditMiddleware(sink))
+}
+
+// AuditMiddleware is the middleware form of WithAudit, suitable for AddMiddleware.
+func AuditMiddleware(sink func(AuditRecord)) Middleware {
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			record := AuditRecord{
+				Method:        req.Method,
+				URL:           req.URL.String(),
+				RequestHeader: req.Header.Clone(),
+			}
+			reqBody, _ := RepeatableReadRequest(req)
+			record.RequestBody = record.capBody(reqBody)
+
+			record.StartAt = time.Now()
+			res, err := next(req)
+			record.Duration = time.Since(record.StartAt)
+			record.Err = err
+			record.Span = FromRequest(req).Span()
+			if err == nil && res != nil {
+				record.StatusCode = res.StatusCode
+				record.Status = res.Status
+				record.ResponseHeader = res.Header.Clone()
+				resBody, _ := RepeatableReadResponse(res)
+				record.ResponseBody = record.capBody(resBody)
+			}
+			sink(record)
+			return res, err
+		}
+	}
+}
+
+func (r *AuditRecord) capBody(body []byte) []byte {
+	if body == nil {
+		return nil
+	}
+	if len(body) > AuditBodyLimit {
+		body = body[:AuditBodyLimit]
+		r.Truncated = true
+	}
+	out := make([]byte, len(body))
+	copy(out, body)
+	return out
+}
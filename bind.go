@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// BindBodyLimit is the largest request body Bind accepts, in bytes.
+var BindBodyLimit int64 = 1 << 20
+
+var (
+	// ErrUnsupportedMediaType is returned (wrapped) by Bind for a non-JSON Content-Type, and by
+	// Client.Send for a body it cannot encode in the requested content type.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrBodyTooLarge is returned (wrapped) by Bind for a body larger than BindBodyLimit.
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// BindError is returned by Bind. Status is the HTTP status that best describes the failure,
+// so that a handler can reply with RespondError(w, r, err.Status, err).
+type BindError struct {
+	Status int
+	Err    error
+}
+
+func (e *BindError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Bind reads the JSON request body into v. It fails with a *BindError when the Content-Type is
+// set but is not JSON (415), the body exceeds BindBodyLimit (413), the body is not valid JSON
+// for v (400), or when v implements Validate() error and validation fails (422).
+func Bind(r *http.Request, v any) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if mediaType != mimeJSON && !strings.HasSuffix(mediaType, "+json") {
+			return &BindError{Status: http.StatusUnsupportedMediaType, Err: fmt.Errorf("%w %q, expected %s", ErrUnsupportedMediaType, ct, mimeJSON)}
+		}
+	}
+	if r.Body == nil {
+		return &BindError{Status: http.StatusBadRequest, Err: errors.New("missing request body")}
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, BindBodyLimit+1))
+	if err != nil {
+		return &BindError{Status: http.StatusBadRequest, Err: fmt.Errorf("read request body: %w", err)}
+	}
+	if int64(len(data)) > BindBodyLimit {
+		return &BindError{Status: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("%w, limit is %d bytes", ErrBodyTooLarge, BindBodyLimit)}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &BindError{Status: http.StatusBadRequest, Err: errors.New("empty request body")}
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return &BindError{Status: http.StatusBadRequest, Err: fmt.Errorf("malformed json body: %w", err)}
+	}
+	if validator, ok := v.(interface{ Validate() error }); ok {
+		if err = validator.Validate(); err != nil {
+			return &BindError{Status: http.StatusUnprocessableEntity, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// fileBody is a request body read from a file. Like repeatableReader it rewinds itself when
+// closed, the file itself is closed once the request is done. Being seekable, it is rewound
+// between attempts instead of being buffered in memory.
+type fileBody struct {
+	*os.File
+}
+
+func (fb *fileBody) SeekStart() error {
+	_, err := fb.Seek(0, io.SeekStart)
+	return err
+}
+
+func (fb *fileBody) Close() error {
+	return fb.SeekStart()
+}
+
+// WithBodyFile sends the content of the file at path as the request body. The file is streamed,
+// with ContentLength set from its size, and is rewound rather than buffered when the request is
+// retried. Content-Type is derived from the file extension unless already set, falling back to
+// application/octet-stream.
+func WithBodyFile(path string) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = &fileBody{File: f}
+			req.ContentLength = info.Size()
+			req.GetBody = func() (io.ReadCloser, error) {
+				return os.Open(path)
+			}
+			if req.ContentLength == 0 {
+				req.Body = http.NoBody
+			}
+			if req.Header.Get("Content-Type") == "" {
+				contentType := mime.TypeByExtension(filepath.Ext(path))
+				if contentType == "" {
+					contentType = "application/octet-stream"
+				}
+				req.Header.Set("Content-Type", contentType)
+			}
+			return next(req)
+		}
+	})
+}
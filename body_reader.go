@@ -6,8 +6,15 @@ import (
 	"net/http"
 )
 
+// repeatableReader is a body that rewinds itself when closed. It keeps the buffered bytes so
+// that RepeatableReadResponse and RepeatableReadRequest can return them without reading again.
 type repeatableReader struct {
 	*bytes.Reader
+	data []byte
+}
+
+func newRepeatableReader(data []byte) *repeatableReader {
+	return &repeatableReader{Reader: bytes.NewReader(data), data: data}
 }
 
 func (rr *repeatableReader) SeekStart() error {
@@ -19,13 +26,18 @@ func (rr *repeatableReader) Close() error {
 	return rr.SeekStart()
 }
 
+// RepeatableReadResponse reads the whole response body and replaces it with a repeatable
+// reader over the same bytes, so that later readers still see the full body.
+//
+// The body is held in memory once: calling it again, e.g. from several stacked middlewares,
+// returns the buffered bytes without copying or reading anything. The returned slice is shared
+// with the body and must not be modified.
 func RepeatableReadResponse(res *http.Response) ([]byte, error) {
 	if res == nil || res.Body == nil {
 		return nil, nil
 	}
 	if rr, ok := res.Body.(*repeatableReader); ok {
-		defer rr.Close()
-		return io.ReadAll(res.Body)
+		return rr.data, rr.SeekStart()
 	}
 	data, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -33,17 +45,18 @@ func RepeatableReadResponse(res *http.Response) ([]byte, error) {
 		return nil, err
 	}
 	res.Body.Close()
-	res.Body = &repeatableReader{Reader: bytes.NewReader(data)}
+	res.Body = newRepeatableReader(data)
 	return data, nil
 }
 
+// RepeatableReadRequest is the request counterpart of RepeatableReadResponse, with the same
+// memory characteristics.
 func RepeatableReadRequest(res *http.Request) ([]byte, error) {
 	if res.Body == nil {
 		return nil, nil
 	}
 	if rr, ok := res.Body.(*repeatableReader); ok {
-		defer rr.Close()
-		return io.ReadAll(res.Body)
+		return rr.data, rr.SeekStart()
 	}
 	data, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -51,6 +64,74 @@ func RepeatableReadRequest(res *http.Request) ([]byte, error) {
 		return nil, err
 	}
 	res.Body.Close()
-	res.Body = &repeatableReader{Reader: bytes.NewReader(data)}
+	res.Body = newRepeatableReader(data)
 	return data, nil
 }
+
+// setContentLength fills in req.ContentLength for bodies whose size can be known up front but
+// that http.NewRequest does not recognize, such as files or any reader with a Len method.
+// Without it such bodies are sent with chunked encoding, which some servers reject.
+func setContentLength(req *http.Request, body io.Reader) {
+	if body == nil || req.ContentLength != 0 || req.Body == http.NoBody {
+		return
+	}
+	n, ok := readerLength(body)
+	if !ok {
+		return
+	}
+	req.ContentLength = n
+	if n == 0 {
+		req.Body = http.NoBody
+	}
+}
+
+func readerLength(body io.Reader) (int64, bool) {
+	switch r := body.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err = r.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// setRequestBody replaces the request body with a repeatable reader over data and keeps
+// ContentLength and GetBody consistent with it.
+func setRequestBody(req *http.Request, data []byte) {
+	req.Body = newRepeatableReader(data)
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// rewinder is implemented by request bodies that can be sent again by moving back to their
+// beginning, such as repeatableReader and fileBody.
+type rewinder interface {
+	SeekStart() error
+}
+
+// rewindRequestBody moves a rewindable request body back to its beginning.
+func rewindRequestBody(req *http.Request) error {
+	if rw, ok := req.Body.(rewinder); ok {
+		return rw.SeekStart()
+	}
+	return nil
+}
+
+// isRewindable reports whether the request body can be sent again without being buffered.
+func isRewindable(body io.ReadCloser) bool {
+	_, ok := body.(rewinder)
+	return ok
+}
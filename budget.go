@@ -0,0 +1,59 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const keyBudget = contextKey("http-budget")
+
+// ErrBudgetExhausted is returned when a request is started after the time budget of its
+// context is spent, see WithBudget.
+var ErrBudgetExhausted = errors.New("request time budget exhausted")
+
+// WithBudget returns a context carrying a time budget of d, shared by every request made with
+// it or a context derived from it. Each request runs with the smaller of its configured timeout
+// and the budget left, so a chain of downstream calls cannot outlast the caller's own deadline.
+// Unlike context.WithTimeout the context itself is never canceled: the budget only bounds the
+// requests of this package. A nested budget can only shrink the one it is derived from.
+func WithBudget(ctx context.Context, d time.Duration) context.Context {
+	deadline := time.Now().Add(d)
+	if parent, ok := budgetDeadline(ctx); ok && parent.Before(deadline) {
+		deadline = parent
+	}
+	return context.WithValue(ctx, keyBudget, deadline)
+}
+
+// RemainingBudget returns the time left in the budget of ctx, which is zero or negative once it
+// is spent. ok is false if ctx carries no budget.
+func RemainingBudget(ctx context.Context) (remaining time.Duration, ok bool) {
+	deadline, ok := budgetDeadline(ctx)
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+func budgetDeadline(ctx context.Context) (time.Time, bool) {
+	if ctx == nil {
+		return time.Time{}, false
+	}
+	deadline, ok := ctx.Value(keyBudget).(time.Time)
+	return deadline, ok
+}
+
+// applyBudget caps the request timeout with the budget left in the request context.
+func applyBudget(ctx context.Context, gv *gValue) error {
+	remaining, ok := RemainingBudget(ctx)
+	if !ok {
+		return nil
+	}
+	if remaining <= 0 {
+		return ErrBudgetExhausted
+	}
+	if gv.Timeout == timeoutNotSet || gv.Timeout <= 0 || gv.Timeout > remaining {
+		gv.Timeout = remaining
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned (wrapped) while reading a body whose digest does not match
+// the checksum announced by the server, see WithVerifyChecksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumHeaders lists the supported headers, strongest first.
+var checksumHeaders = []struct {
+	header string
+	name   string
+	hash   func() hash.Hash
+}{
+	{"X-Checksum-Sha256", "sha256", sha256.New},
+	{"X-Checksum-Sha1", "sha1", sha1.New},
+	{"Content-MD5", "md5", md5.New},
+}
+
+// WithVerifyChecksum verifies the response body against the X-Checksum-Sha256, X-Checksum-Sha1
+// or Content-MD5 header, whichever is present first in that order. The digest is computed while
+// the body is consumed (Save, GetBody, ...), and reading the end of a corrupted body fails with
+// ErrChecksumMismatch. The checksum may be hex or base64 encoded. Responses without any of these
+// headers are not checked.
+func WithVerifyChecksum() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			res, err := next(req)
+			if err != nil || res == nil || res.Body == nil {
+				return res, err
+			}
+			for _, h := range checksumHeaders {
+				if sum := res.Header.Get(h.header); sum != "" {
+					expected, err := decodeChecksum(sum)
+					if err != nil {
+						res.Body.Close()
+						return nil, fmt.Errorf("%w: invalid %s header %q", ErrChecksumMismatch, h.header, sum)
+					}
+					res.Body = &checksumReader{body: res.Body, hash: h.hash(), name: h.name, expected: expected}
+					break
+				}
+			}
+			return res, nil
+		}
+	})
+}
+
+func decodeChecksum(sum string) ([]byte, error) {
+	sum = strings.TrimSpace(sum)
+	if b, err := hex.DecodeString(sum); err == nil {
+		return b, nil
+	}
+	return base64.StdEncoding.DecodeString(sum)
+}
+
+type checksumReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	name     string
+	expected []byte
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := r.hash.Sum(nil); !bytes.Equal(actual, r.expected) {
+			return n, fmt.Errorf("%w: %s expected %x, got %x", ErrChecksumMismatch, r.name, r.expected, actual)
+		}
+	}
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	return r.body.Close()
+}
@@ -1,17 +1,22 @@
 package http
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,8 +24,15 @@ const defaultConnectTimeout = 15 * time.Second
 
 // NewClient creates a new HTTP client with a default pooled transport and a 15-second timeout.
 func NewClient() Client {
+	transport := DefaultPooledTransport()
+	dialer := newDialer(transport.DialContext)
+	transport.DialContext = dialer.DialContext
 	cli := &clientImpl{
-		transport: DefaultPooledTransport(),
+		transport: transport,
+		dialer:    dialer,
+		derived:   new(sync.Map),
+		profiles:  new(sync.Map),
+		noProxy:   new(noProxyFilter),
 	}
 	return cli
 }
@@ -28,8 +40,27 @@ func NewClient() Client {
 // clientImpl is the concrete implementation of the Client interface.
 type clientImpl struct {
 	transport *http.Transport
+	// dialer is installed as the transport's DialContext and is shared with forked clients.
+	dialer *dialer
+	// derived caches transports cloned from transport for per-request settings, such as a
+	// minimum TLS version. It is shared with forked clients, like the transport itself.
+	derived *sync.Map
+	// profiles holds the transports registered with RegisterProfile, by name. It is shared with
+	// forked clients too.
+	profiles *sync.Map
+	// noProxy is the proxy bypass list of SetNoProxyCIDRs, shared with forked clients.
+	noProxy *noProxyFilter
+	// handler serves the requests in memory instead of the transports, see SetHandler.
+	handler http.Handler
 	// middlewares is the chain of client-level middlewares.
 	middlewares []Middleware
+	// names holds the name of each middleware, "" for unnamed ones, see AddNamedMiddleware.
+	names []string
+	// hooks are the before and after hooks, kept apart from the middlewares so that they always
+	// run right around the send, see middlewareContext.
+	hooks clientHooks
+	// chain caches the composed middlewares, it is reset whenever they change.
+	chain atomic.Pointer[handlerChain]
 }
 
 // Fork creates a new client instance. If withMiddlewares is true, it performs a shallow copy
@@ -37,11 +68,18 @@ type clientImpl struct {
 func (client *clientImpl) Fork(withMiddlewares bool) Client {
 	cli := &clientImpl{
 		transport: client.transport,
+		dialer:    client.dialer,
+		derived:   client.derived,
+		profiles:  client.profiles,
+		noProxy:   client.noProxy,
+		handler:   client.handler,
 	}
 	if withMiddlewares {
 		ms := make([]Middleware, len(client.middlewares))
 		copy(ms, client.middlewares)
 		cli.middlewares = ms
+		cli.names = slices.Clone(client.names)
+		cli.hooks = client.hooks.clone()
 	}
 	return cli
 }
@@ -63,6 +101,71 @@ func (client *clientImpl) DisableKeepAlive(disable bool) Client {
 	return client
 }
 
+// SetBaseURL adds a middleware that resolves relative request URLs (no scheme and host) against
+// base. The request path is appended to the base path, so with a base of
+// "https://api.example.com/v2", "/users?page=2" becomes "https://api.example.com/v2/users?page=2".
+func (client *clientImpl) SetBaseURL(base string) Client {
+	baseURL, err := url.Parse(base)
+	return client.AddMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			if err != nil {
+				return nil, fmt.Errorf("invalid base url %q: %w", base, err)
+			}
+			if req.URL.Scheme == "" && req.URL.Host == "" {
+				u := *req.URL
+				u.Scheme = baseURL.Scheme
+				u.Host = baseURL.Host
+				u.User = baseURL.User
+				/* join the escaped paths, so that an escaped "/" in either stays escaped */
+				joined, err := url.Parse(strings.TrimSuffix(baseURL.EscapedPath(), "/") + "/" + strings.TrimPrefix(req.URL.EscapedPath(), "/"))
+				if err != nil {
+					return nil, fmt.Errorf("invalid request path %q: %w", req.URL.EscapedPath(), err)
+				}
+				u.Path, u.RawPath = joined.Path, joined.RawPath
+				req.URL = &u
+				req.Host = ""
+			}
+			return next(req)
+		}
+	})
+}
+
+// SetMaxRedirects adds a middleware that limits the number of redirects followed by each request.
+// Once a request would exceed n hops it fails with an error wrapping ErrTooManyRedirects.
+func (client *clientImpl) SetMaxRedirects(n int) Client {
+	client.AddMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).MaxRedirects = n
+			return next(req)
+		}
+	})
+	return client
+}
+
+// SetRedirectPolicy adds a middleware that calls fn before following each redirect, like
+// http.Client.CheckRedirect. Returning an error stops the redirects.
+func (client *clientImpl) SetRedirectPolicy(fn func(req *http.Request, via []*http.Request) error) Client {
+	return client.AddMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).RedirectPolicy = fn
+			return next(req)
+		}
+	})
+}
+
+// SetErrorWrapping adds a middleware that controls whether request errors are wrapped in a
+// *RequestError, which prefixes the message with the method and URL. The wrapped error is
+// still reachable through errors.Is and errors.As.
+func (client *clientImpl) SetErrorWrapping(enable bool) Client {
+	client.AddMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).WrapErrors = enable
+			return next(req)
+		}
+	})
+	return client
+}
+
 // SetMock adds a middleware that intercepts requests and returns a mocked response.
 func (client *clientImpl) SetMock(fn Endpoint) Client {
 	client.AddMiddleware(func(next Endpoint) Endpoint {
@@ -87,7 +190,7 @@ func (client *clientImpl) SetDebug(w HTTPLogger) Client {
 
 // SetRetry adds a middleware that sets a default retry policy for all requests.
 func (client *clientImpl) SetRetry(opt RetryOption) Client {
-	client.AddMiddleware(func(next Endpoint) Endpoint {
+	client.AddNamedMiddleware(retryMiddlewareName, func(next Endpoint) Endpoint {
 		return func(req *http.Request) (*http.Response, error) {
 			getValue(req).RetryOption = &opt
 			return next(req)
@@ -111,37 +214,109 @@ func (client *clientImpl) SetHeaders(hder map[string]string) Client {
 	})
 }
 
+// SetAccept sets the default Accept header for all requests, see WithAccept.
+func (client *clientImpl) SetAccept(mediaType string) Client {
+	return client.SetHeader("Accept", mediaType)
+}
+
+// SetUserAgentTemplate sets a descriptive default User-Agent for all requests, built once as
+// "name/version (go/<go version>; <os>/<arch>)". WithHeader("User-Agent", ...) still overrides it.
+func (client *clientImpl) SetUserAgentTemplate(name, version string) Client {
+	return client.SetHeader("User-Agent", userAgent(name, version))
+}
+
+func userAgent(name, version string) string {
+	return fmt.Sprintf("%s/%s (go/%s; %s/%s)", name, version, strings.TrimPrefix(runtime.Version(), "go"), runtime.GOOS, runtime.GOARCH)
+}
+
+// SetHost adds a middleware that sets the Host presented to the server for all requests, see WithHost.
+func (client *clientImpl) SetHost(host string) Client {
+	return client.AddMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Host = host
+			return next(req)
+		}
+	})
+}
+
 // AddMiddleware appends one or more middlewares to the end of the client's middleware chain.
 func (client *clientImpl) AddMiddleware(m ...Middleware) Client {
 	client.middlewares = append(client.middlewares, m...)
+	client.names = append(client.names, make([]string, len(m))...)
+	client.chain.Store(nil)
 	return client
 }
 
 // PrependMiddleware adds one or more middlewares to the beginning of the client's middleware chain.
 func (client *clientImpl) PrependMiddleware(m ...Middleware) Client {
 	client.middlewares = append(m, client.middlewares...)
+	client.names = append(make([]string, len(m)), client.names...)
+	client.chain.Store(nil)
 	return client
 }
 
-// AddBeforeHook adds a middleware that executes a hook function before the request is sent.
+// retryMiddlewareName names the middleware installed by SetRetry, so that calling it again
+// replaces the previous policy.
+const retryMiddlewareName = "retry"
+
+// AddNamedMiddleware appends m under name, or replaces in place the middleware already added
+// under that name, which is reported to the package logger.
+func (client *clientImpl) AddNamedMiddleware(name string, m Middleware) Client {
+	if i := slices.Index(client.names, name); i >= 0 && name != "" {
+		logf("http: middleware %q added twice, the previous one is replaced", name)
+		client.middlewares[i] = m
+		client.chain.Store(nil)
+		return client
+	}
+	client.AddMiddleware(m)
+	client.names[len(client.names)-1] = name
+	return client
+}
+
+// AddBeforeHook adds a hook function that executes right before the request is sent, after
+// every middleware. Hooks run in the order they were added.
 func (client *clientImpl) AddBeforeHook(hook func(*http.Request)) Client {
-	return client.AddMiddleware(func(next Endpoint) Endpoint {
-		return func(req *http.Request) (*http.Response, error) {
-			hook(req)
-			return next(req)
-		}
-	})
+	client.hooks.before = append(client.hooks.before, hook)
+	client.chain.Store(nil)
+	return client
 }
 
-// AddAfterHook adds a middleware that executes a hook function after a successful response is received.
+// AddAfterHook adds a hook function that executes right after a successful response is received,
+// before any middleware sees it. Hooks run in the order they were added.
 func (client *clientImpl) AddAfterHook(hook func(*http.Response)) Client {
+	client.hooks.after = append(client.hooks.after, hook)
+	client.chain.Store(nil)
+	return client
+}
+
+// SetHeaderFinalizer sets a function run on the request headers after every middleware and hook,
+// right before each attempt is sent. It replaces the finalizer set by a previous call.
+func (client *clientImpl) SetHeaderFinalizer(fn func(http.Header)) Client {
+	client.hooks.finalizer = fn
+	client.chain.Store(nil)
+	return client
+}
+
+// EnableRecorder records every exchange of the client to w, see RecorderMiddleware.
+func (client *clientImpl) EnableRecorder(w io.Writer) Client {
+	return client.AddNamedMiddleware(recorderMiddlewareName, RecorderMiddleware(w))
+}
+
+// AddResponseInterceptor adds a middleware that passes every successful response to fn, which
+// may return a replacement response or an error that fails the request.
+func (client *clientImpl) AddResponseInterceptor(fn func(*http.Response) (*http.Response, error)) Client {
 	return client.AddMiddleware(func(next Endpoint) Endpoint {
 		return func(req *http.Request) (*http.Response, error) {
 			res, err := next(req)
-			if err == nil && res != nil {
-				hook(res)
+			if err != nil || res == nil {
+				return res, err
 			}
-			return res, err
+			out, err := fn(res)
+			if out == nil && res.Body != nil {
+				// The original response is dropped, release its connection.
+				res.Body.Close()
+			}
+			return out, err
 		}
 	})
 }
@@ -149,28 +324,173 @@ func (client *clientImpl) AddAfterHook(hook func(*http.Response)) Client {
 // MakeDoer constructs an Endpoint function (which satisfies the Doer interface) by applying
 // all client-level and request-level option middlewares.
 func (client *clientImpl) MakeDoer(opts ...Option) Doer {
-	return (Doer)(client.makeFinalHandler(client.getOptionMiddlewares(opts...)...))
+	return (Doer)(client.makeFinalHandler(client.resolveOptions(opts...)))
 }
 
 // DoRequest executes a pre-constructed http.Request using the client's configuration and
 // any additional per-request options.
 func (client *clientImpl) DoRequest(req *http.Request, opts ...Option) *Response {
-	res, err := client.makeFinalHandler(client.getOptionMiddlewares(opts...)...)(req)
-	return buildResponse(req.Context(), res, err)
+	return client.do(req.Context(), req, client.resolveOptions(opts...))
 }
 
 // Do is the core method for creating and executing an HTTP request.
 func (client *clientImpl) Do(ctx context.Context, method string, uri string, body io.Reader, opts ...Option) *Response {
+	return client.doURL(ctx, method, uri, body, client.resolveOptions(opts...))
+}
+
+// doURL builds the request for uri and runs it with the resolved options.
+func (client *clientImpl) doURL(ctx context.Context, method string, uri string, body io.Reader, opt *options) *Response {
 	uri = client.rewriteURL(ctx, uri)
 	req, err := http.NewRequest(method, uri, body)
 	if err != nil {
 		return buildResponse(ctx, nil, err)
 	}
+	setContentLength(req, body)
 	if ctx != nil {
 		req = req.WithContext(ctx)
 	}
-	res, err := client.makeFinalHandler(client.getOptionMiddlewares(opts...)...)(req)
-	return buildResponse(ctx, res, err)
+	return client.do(ctx, req, opt)
+}
+
+// do runs req through the middleware chain. The per-request value is created up front so
+// that the state collected while handling the request can be exposed on the Response.
+//
+// When nothing configures the request, it is sent directly: this fast path skips the
+// per-request value and the middleware chain, see isLean.
+func (client *clientImpl) do(ctx context.Context, req *http.Request, opt *options) *Response {
+	if client.isLean(req, opt) {
+		res, err := client.send(req)
+		r := buildResponse(ctx, res, err)
+		r.value = &leanValue
+		return r
+	}
+	start := time.Now()
+	gv := getOrCreateValue(req)
+	req = setValue(req, gv)
+	res, err := client.makeFinalHandler(opt)(req)
+	r := buildResponse(ctx, res, err)
+	r.value = gv
+	r.deadline = bodyDeadline(gv, start)
+	return r
+}
+
+// bodyDeadline returns the time by which a response body must be read: the request timeout,
+// which bounds all the attempts, counted from start. Zero means no deadline: without a request
+// timeout the pooled client's timer of the final attempt already covers its body.
+func bodyDeadline(gv *gValue, start time.Time) time.Time {
+	if gv.Timeout == timeoutNotSet || gv.Timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(gv.Timeout)
+}
+
+// leanValue is the per-request value reported by requests sent on the fast path, which are
+// sent once with the defaults. It is shared and must never be modified.
+var leanValue = gValue{Timeout: timeoutNotSet, MaxRedirects: redirectsNotSet, Attempts: 1}
+
+// isLean reports whether req can skip the middleware chain: the client has no middleware nor
+// hook (or they are bypassed), the call has no option, and the request context carries neither
+// a time budget nor the value of an enclosing request. Checked per call, so that features
+// enabled later are honored.
+func (client *clientImpl) isLean(req *http.Request, opt *options) bool {
+	if len(opt.Middlewares) > 0 || getValue(req) != nil {
+		return false
+	}
+	if !opt.BypassClient && !client.handlerChain().lean {
+		return false
+	}
+	_, hasBudget := budgetDeadline(req.Context())
+	return !hasBudget
+}
+
+// Async runs Do in a new goroutine and delivers the response on the returned channel, which
+// is buffered so the goroutine never blocks even if nobody receives.
+func (client *clientImpl) Async(ctx context.Context, method string, uri string, body io.Reader, opts ...Option) <-chan *Response {
+	ch := make(chan *Response, 1)
+	go func() {
+		ch <- client.Do(ctx, method, uri, body, opts...)
+	}()
+	return ch
+}
+
+// Stream runs the request in the background and emits each top-level JSON value of the response
+// body on the first channel as soon as it is decoded: the elements of an array, or a sequence of
+// concatenated (e.g. newline delimited) values. Reading stops while the consumer does not receive,
+// which throttles the transfer. Once the stream ends, the error channel delivers a single error,
+// nil on success, and both channels are closed; canceling ctx stops the stream and closes the body.
+// Streams are long-lived, so no timeout applies unless given, e.g. WithTimeout in opts.
+func (client *clientImpl) Stream(ctx context.Context, method string, uri string, body io.Reader, opts ...Option) (<-chan json.RawMessage, <-chan error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	values := make(chan json.RawMessage)
+	errs := make(chan error, 1)
+	opts = append([]Option{WithTimeout(0)}, opts...)
+	go func() {
+		defer close(errs)
+		defer close(values)
+		err := client.Do(ctx, method, uri, body, opts...).HandleResult(func(res *http.Response) error {
+			if res.Body == nil {
+				return nil
+			}
+			return decodeStream(ctx, res.Body, values)
+		})
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			err = ctxErr
+		}
+		errs <- err
+	}()
+	return values, errs
+}
+
+// decodeStream sends every top-level JSON value of r to values, the elements of an array or
+// concatenated values.
+func decodeStream(ctx context.Context, r io.Reader, values chan<- json.RawMessage) error {
+	br := bufio.NewReader(r)
+	isArray, err := startsWithArray(br)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(br)
+	if isArray {
+		if _, err = dec.Token(); err != nil {
+			return fmt.Errorf("decode json stream: %w", err)
+		}
+	}
+	for !isArray || dec.More() {
+		var value json.RawMessage
+		if err = dec.Decode(&value); err == io.EOF && !isArray {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decode json stream: %w", err)
+		}
+		select {
+		case values <- value:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if _, err = dec.Token(); err != nil {
+		return fmt.Errorf("decode json stream: %w", err)
+	}
+	return nil
+}
+
+// startsWithArray skips leading white space and reports whether the next byte opens an array.
+func startsWithArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b == '[', br.UnreadByte()
+	}
 }
 
 // rewriteURL checks if the URL has a custom protocol scheme and rewrites it if a rewriter is registered.
@@ -189,16 +509,74 @@ func (client *clientImpl) Download(ctx context.Context, uri string, w io.Writer,
 	return client.Do(ctx, "GET", uri, nil, opts...).Save(w)
 }
 
+// DownloadToFile is a convenience method for GET requests that saves the response body to a file.
+// See Response.SaveToFile for the file handling guarantees.
+func (client *clientImpl) DownloadToFile(ctx context.Context, uri string, path string, opts ...Option) error {
+	return client.Do(ctx, "GET", uri, nil, opts...).SaveToFile(path)
+}
+
 // Get is a convenience method for making a GET request.
 func (client *clientImpl) Get(ctx context.Context, uri string, opts ...Option) *Response {
 	return client.Do(ctx, "GET", uri, nil, opts...)
 }
 
+// GetBytes is a convenience method for making a GET request and reading the whole body.
+func (client *clientImpl) GetBytes(ctx context.Context, uri string, opts ...Option) ([]byte, *http.Response, error) {
+	return client.Get(ctx, uri, opts...).bytes()
+}
+
+// Head is a convenience method for making a HEAD request.
+func (client *clientImpl) Head(ctx context.Context, uri string, opts ...Option) *Response {
+	return client.Do(ctx, "HEAD", uri, nil, opts...)
+}
+
+// defaultPingTimeout bounds Ping unless a timeout option is given.
+const defaultPingTimeout = 5 * time.Second
+
+// Ping checks that uri answers with a 2xx status. It sends a HEAD request and falls back to GET
+// when HEAD is not supported (405 or 501). The body is drained in both cases. Any other status
+// fails with a *StatusError.
+func (client *clientImpl) Ping(ctx context.Context, uri string, opts ...Option) error {
+	opts = append([]Option{WithTimeout(defaultPingTimeout)}, opts...)
+	method := http.MethodHead
+	res := client.Head(ctx, uri, opts...)
+	if err := res.Error(); err != nil {
+		return err
+	}
+	if res.StatusCode() == http.StatusMethodNotAllowed || res.StatusCode() == http.StatusNotImplemented {
+		method = http.MethodGet
+		res = client.Get(ctx, uri, opts...)
+		if err := res.Error(); err != nil {
+			return err
+		}
+	}
+	if res.StatusCode() < 200 || res.StatusCode() >= 300 {
+		if res.Request != nil {
+			return newStatusError(res.Request, res.Response, nil)
+		}
+		// A mock response may not carry its request.
+		return &StatusError{Method: method, URL: uri, StatusCode: res.StatusCode(), Status: res.Status}
+	}
+	return nil
+}
+
+// Options is a convenience method for making an OPTIONS request.
+func (client *clientImpl) Options(ctx context.Context, uri string, opts ...Option) *Response {
+	return client.Do(ctx, "OPTIONS", uri, nil, opts...)
+}
+
 // Post is a convenience method for making a POST request with an io.Reader body.
 func (client *clientImpl) Post(ctx context.Context, urlstr string, data io.Reader, opts ...Option) *Response {
 	return client.Do(ctx, "POST", urlstr, data, opts...)
 }
 
+// PostFile is a convenience method for making a POST request whose body is the file at path,
+// see WithBodyFile.
+func (client *clientImpl) PostFile(ctx context.Context, urlstr string, path string, opts ...Option) *Response {
+	opts = append([]Option{WithBodyFile(path)}, opts...)
+	return client.Do(ctx, "POST", urlstr, nil, opts...)
+}
+
 // Delete is a convenience method for making a DELETE request with an io.Reader body.
 func (client *clientImpl) Delete(ctx context.Context, urlstr string, data io.Reader, opts ...Option) *Response {
 	return client.Do(ctx, "DELETE", urlstr, data, opts...)
@@ -212,38 +590,61 @@ func (client *clientImpl) Put(ctx context.Context, urlstr string, data io.Reader
 // PostForm is a convenience method for making a POST request with "application/x-www-form-urlencoded" data.
 // It automatically sets the Content-Type header.
 func (client *clientImpl) PostForm(ctx context.Context, urlstr string, data map[string]any, opts ...Option) *Response {
-	values := url.Values{}
-	for k, v := range data {
-		values.Set(k, fmt.Sprint(v))
-	}
-	opts = append([]Option{WithHeader("Content-Type", "application/x-www-form-urlencoded")}, opts...)
-	return client.Post(ctx, urlstr, strings.NewReader(values.Encode()), opts...)
+	return client.Send(ctx, "POST", urlstr, data, contentTypeForm, opts...)
+}
+
+// PostFormValues is like PostForm but sends values as they are, keeping repeated keys.
+func (client *clientImpl) PostFormValues(ctx context.Context, urlstr string, values url.Values, opts ...Option) *Response {
+	return client.Send(ctx, "POST", urlstr, values, contentTypeForm, opts...)
 }
 
 // PostJSON is a convenience method for making a POST request with a JSON body.
 // It handles various data types (string, []byte, io.Reader, or any marshallable struct) and sets the Content-Type header.
 func (c *clientImpl) PostJSON(ctx context.Context, urlstr string, data any, opts ...Option) *Response {
-	var payload io.Reader
-	switch d := data.(type) {
-	case string:
-		payload = strings.NewReader(d)
-	case []byte:
-		payload = bytes.NewBuffer(d)
-	case json.RawMessage:
-		payload = bytes.NewBuffer(d)
-	case nil:
-		// do nothing
-	case io.Reader:
-		payload = d
-	default:
-		bs, err := json.Marshal(data)
-		if err != nil {
-			return buildResponse(ctx, nil, err)
-		}
-		payload = bytes.NewBuffer(bs)
-	}
-	opts = append([]Option{WithHeader("Content-Type", "application/json; charset=utf-8")}, opts...)
-	return c.Post(ctx, urlstr, payload, opts...)
+	return c.Send(ctx, "POST", urlstr, data, contentTypeJSON, opts...)
+}
+
+// Send encodes body according to contentType, see encodeBody, and sends it with the
+// Content-Type header set to contentType, unless it is empty.
+func (client *clientImpl) Send(ctx context.Context, method string, uri string, body any, contentType string, opts ...Option) *Response {
+	payload, err := encodeBody(body, contentType)
+	if err != nil {
+		return buildResponse(ctx, nil, err)
+	}
+	if contentType != "" {
+		opts = append([]Option{WithHeader("Content-Type", contentType)}, opts...)
+	}
+	return client.Do(ctx, method, uri, payload, opts...)
+}
+
+// PostJSONBytes is like PostJSON but reads the whole response body.
+func (client *clientImpl) PostJSONBytes(ctx context.Context, urlstr string, data any, opts ...Option) ([]byte, *http.Response, error) {
+	return client.PostJSON(ctx, urlstr, data, opts...).bytes()
+}
+
+// PostBytes is like Post but reads the whole response body.
+func (client *clientImpl) PostBytes(ctx context.Context, urlstr string, data io.Reader, opts ...Option) ([]byte, *http.Response, error) {
+	return client.Post(ctx, urlstr, data, opts...).bytes()
+}
+
+// PutBytes is like Put but reads the whole response body.
+func (client *clientImpl) PutBytes(ctx context.Context, urlstr string, data io.Reader, opts ...Option) ([]byte, *http.Response, error) {
+	return client.Put(ctx, urlstr, data, opts...).bytes()
+}
+
+// DeleteBytes is like Delete but reads the whole response body.
+func (client *clientImpl) DeleteBytes(ctx context.Context, urlstr string, data io.Reader, opts ...Option) ([]byte, *http.Response, error) {
+	return client.Delete(ctx, urlstr, data, opts...).bytes()
+}
+
+// PostFormBytes is like PostForm but reads the whole response body.
+func (client *clientImpl) PostFormBytes(ctx context.Context, urlstr string, data map[string]any, opts ...Option) ([]byte, *http.Response, error) {
+	return client.PostForm(ctx, urlstr, data, opts...).bytes()
+}
+
+// SendBytes is like Send but reads the whole response body.
+func (client *clientImpl) SendBytes(ctx context.Context, method string, uri string, body any, contentType string, opts ...Option) ([]byte, *http.Response, error) {
+	return client.Send(ctx, method, uri, body, contentType, opts...).bytes()
 }
 
 // makeFinalHandler constructs the final request-processing endpoint by chaining all middlewares.
@@ -251,69 +652,236 @@ func (c *clientImpl) PostJSON(ctx context.Context, urlstr string, data any, opts
 // 1. `middlewareInitCtx` (always first to ensure context exists)
 // 2. Client-level middlewares (in reverse order of addition)
 // 3. Request-level (Option) middlewares (in reverse order of addition)
-// 4. `middlewareContext` (applies timeout, retry, debug, etc.)
+// 4. `middlewareContext` (applies timeout, retry, debug, the client hooks, etc.)
 // 5. The actual `client.Client.Do` call.
-func (client *clientImpl) makeFinalHandler(extraMiddlewares ...Middleware) Endpoint {
-	next := func(req *http.Request) (*http.Response, error) {
-		// The final step in the middleware chain is to execute the request.
-		// **Design Rationale: Why use a pooled http.Client with a Timeout field?**
-		//
-		// This design is crucial for ensuring that request timeouts and TCP connection reuse (Keep-Alive)
-		// work together correctly and robustly.
-		//
-		// 1. **Leveraging `setRequestCancel`**: When an `http.Client` has a non-zero `Timeout`, its `Do`
-		//    method calls an internal function `setRequestCancel`. This function sets up a timer. If the
-		//    request takes too long, the timer triggers `transport.CancelRequest(req)`. This is a specific
-		//    cancellation signal that the `http.Transport` is designed to understand perfectly.
-		//
-		// 2. **Guaranteed Connection Cleanup**: Upon receiving a `CancelRequest` signal, the `Transport`
-		//    knows it's a client-initiated cancellation. It will then safely interrupt the request while
-		//    ensuring the underlying TCP connection is properly "drained" (reading and discarding any
-		//    remaining response body) before returning it to the connection pool. This guarantees
-		//    the connection is clean and ready for reuse.
-		//
-		// 3. **Avoiding Ambiguity**: In contrast, relying solely on a request's `context` for timeouts
-		//    can be less reliable in some edge cases. When only the `context` is canceled, the `Transport`
-		//    sees a more generic cancellation signal. Under certain network conditions or with non-standard
-		//    server behaviors, the `Transport` might conservatively decide to close the connection instead
-		//    of attempting to reuse it, to avoid state corruption.
-		//
-		// 4. **Best Practice**: Therefore, creating a temporary `http.Client` for each request and setting
-		//    its `Timeout` field is the most robust way to handle per-request timeouts in Go. It ensures
-		//    maximum connection reuse and prevents resource leaks, which is why this library adopts this
-		//    pattern using a `sync.Pool` for efficiency.
-		timeout := defaultConnectTimeout // Fallback to default connect timeout
-		gv := getValue(req)
-		if gv != nil && gv.Timeout != timeoutNotSet {
-			timeout = gv.Timeout
-		}
-		c := poolGetClient(client.transport, timeout)
-		defer poolPutClient(c)
-		return c.Do(req)
-	}
-
-	next = middlewareContext(next)
-
-	// Apply all middlewares in reverse order to create the chain.
-	for i := len(extraMiddlewares) - 1; i >= 0; i-- {
-		next = extraMiddlewares[i](next)
+//
+// Steps 1, 2, 4 and 5 only change when the client's middlewares do, so they are composed once
+// and cached (see handlerChain); only the request-level middlewares are composed per call.
+// Step 2 and the client hooks are skipped when the options ask to bypass the client middlewares.
+func (client *clientImpl) makeFinalHandler(opt *options) Endpoint {
+	chain := client.handlerChain()
+	inner := chain.inner
+	if opt.BypassClient {
+		inner = chain.bare
 	}
+	for i := len(opt.Middlewares) - 1; i >= 0; i-- {
+		inner = opt.Middlewares[i](inner)
+	}
+	if opt.BypassClient {
+		return middlewareInitCtx(inner)
+	}
+	return func(req *http.Request) (*http.Response, error) {
+		// A request sent from within another request of this client must not pick up the
+		// outer request's option middlewares, so the inner endpoint is also set when the
+		// context already carries one.
+		if len(opt.Middlewares) > 0 || req.Context().Value(chain) != nil {
+			req = req.WithContext(context.WithValue(req.Context(), chain, inner))
+		}
+		return chain.outer(req)
+	}
+}
+
+// handlerChain is the cached client-level part of the middleware chain. The request-level
+// middlewares sit between the client middlewares and middlewareContext, so the client
+// middlewares end in dispatch, which continues with the endpoint stored in the request
+// context under the chain itself.
+type handlerChain struct {
+	outer Endpoint
+	inner Endpoint
+	// bare is inner without the client hooks, for requests bypassing the client.
+	bare Endpoint
+	// lean is set when the client has neither middlewares nor hooks, nor a header finalizer.
+	lean bool
+}
+
+func (chain *handlerChain) dispatch(req *http.Request) (*http.Response, error) {
+	if next, ok := req.Context().Value(chain).(Endpoint); ok {
+		return next(req)
+	}
+	return chain.inner(req)
+}
+
+// handlerChain returns the cached chain, composing it if the middlewares changed since.
+func (client *clientImpl) handlerChain() *handlerChain {
+	if chain := client.chain.Load(); chain != nil {
+		return chain
+	}
+	chain := &handlerChain{
+		inner: middlewareContext(client.send, client.hooks.clone()),
+		bare:  middlewareContext(client.send, clientHooks{}),
+		lean:  len(client.middlewares) == 0 && client.hooks.empty(),
+	}
+	next := Endpoint(chain.dispatch)
 	for i := len(client.middlewares) - 1; i >= 0; i-- {
 		next = client.middlewares[i](next)
 	}
 	// This middleware must be the outermost one to initialize the request context value.
-	next = middlewareInitCtx(next)
+	chain.outer = middlewareInitCtx(next)
+	client.chain.Store(chain)
+	return chain
+}
+
+// send is the final step in the middleware chain, it executes the request.
+func (client *clientImpl) send(req *http.Request) (*http.Response, error) {
+	// **Design Rationale: Why use a pooled http.Client with a Timeout field?**
+	//
+	// This design is crucial for ensuring that request timeouts and TCP connection reuse (Keep-Alive)
+	// work together correctly and robustly.
+	//
+	// 1. **Leveraging `setRequestCancel`**: When an `http.Client` has a non-zero `Timeout`, its `Do`
+	//    method calls an internal function `setRequestCancel`. This function sets up a timer. If the
+	//    request takes too long, the timer triggers `transport.CancelRequest(req)`. This is a specific
+	//    cancellation signal that the `http.Transport` is designed to understand perfectly.
+	//
+	// 2. **Guaranteed Connection Cleanup**: Upon receiving a `CancelRequest` signal, the `Transport`
+	//    knows it's a client-initiated cancellation. It will then safely interrupt the request while
+	//    ensuring the underlying TCP connection is properly "drained" (reading and discarding any
+	//    remaining response body) before returning it to the connection pool. This guarantees
+	//    the connection is clean and ready for reuse.
+	//
+	// 3. **Avoiding Ambiguity**: In contrast, relying solely on a request's `context` for timeouts
+	//    can be less reliable in some edge cases. When only the `context` is canceled, the `Transport`
+	//    sees a more generic cancellation signal. Under certain network conditions or with non-standard
+	//    server behaviors, the `Transport` might conservatively decide to close the connection instead
+	//    of attempting to reuse it, to avoid state corruption.
+	//
+	// 4. **Best Practice**: Therefore, creating a temporary `http.Client` for each request and setting
+	//    its `Timeout` field is the most robust way to handle per-request timeouts in Go. It ensures
+	//    maximum connection reuse and prevents resource leaks, which is why this library adopts this
+	//    pattern using a `sync.Pool` for efficiency.
+	timeout := defaultConnectTimeout // Fallback to default connect timeout
+	gv := getValue(req)
+	if gv != nil && gv.Timeout != timeoutNotSet {
+		timeout = gv.Timeout
+	}
+	var tr http.RoundTripper = client.transportFor(gv)
+	if client.handler != nil {
+		tr = handlerTransport{handler: client.handler}
+	}
+	c := poolGetClient(tr, timeout)
+	defer poolPutClient(c)
+	c.CheckRedirect = redirectPolicy(gv)
+	tracker := new(connTracker)
+	res, err := c.Do(tracker.track(req))
+	if err != nil && !tracker.gotConn.Load() && isTimeout(err) {
+		err = fmt.Errorf("%w: %w", ErrConnPoolTimeout, err)
+	}
+	return res, err
+}
+
+// ErrConnPoolTimeout is returned (wrapped, along with the timeout error) when a request times
+// out before it even obtained a connection: the pool was exhausted (see
+// http.Transport.MaxConnsPerHost) or dialing a new connection took too long. A timeout once the
+// request was sent is not wrapped.
+var ErrConnPoolTimeout = errors.New("timed out waiting for a connection")
+
+// connTracker records whether the transport handed a connection to the request.
+type connTracker struct {
+	gotConn atomic.Bool
+	trace   httptrace.ClientTrace
+}
 
-	return next
+func (t *connTracker) track(req *http.Request) *http.Request {
+	t.trace.GotConn = func(httptrace.GotConnInfo) { t.gotConn.Store(true) }
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &t.trace))
 }
 
-// getOptionMiddlewares processes a slice of Option functions and returns the resulting slice of middlewares.
-func (client *clientImpl) getOptionMiddlewares(opts ...Option) []Middleware {
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// transportFor returns the transport that should carry a request: the transport of the
+// profile selected with WithProfile, or else the client's transport. Requests without
+// transport-level overrides share it; otherwise a clone is derived from it once and cached,
+// so requests with the same overrides share a connection pool.
+func (client *clientImpl) transportFor(gv *gValue) *http.Transport {
+	base := client.transport
+	key := derivedKey{}
+	if gv != nil && gv.Profile != "" {
+		if p, ok := client.profiles.Load(gv.Profile); ok {
+			base = p.(*profile).tr
+			key.profile, key.gen = gv.Profile, p.(*profile).gen
+		}
+	}
+	if gv == nil || (gv.MinTLSVersion == 0 && gv.HTTPVersion == 0) {
+		return base
+	}
+	key.minTLS, key.http = gv.MinTLSVersion, gv.HTTPVersion
+	if tr, ok := client.derived.Load(key); ok {
+		return tr.(*http.Transport)
+	}
+	tr := base.Clone()
+	if gv.MinTLSVersion != 0 {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.MinVersion = gv.MinTLSVersion
+	}
+	switch gv.HTTPVersion {
+	case 1:
+		// A non-nil empty TLSNextProto disables HTTP/2, ALPN must not offer it either.
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case 2:
+		// A custom DialContext disables HTTP/2 unless it is asked for explicitly.
+		tr.ForceAttemptHTTP2 = true
+	}
+	actual, _ := client.derived.LoadOrStore(key, tr)
+	if key.profile != "" {
+		// The profile may have been registered again meanwhile, its clones must not linger.
+		if p, ok := client.profiles.Load(key.profile); !ok || p.(*profile).gen != key.gen {
+			client.dropDerived(key.profile, key.gen)
+		}
+	}
+	return actual.(*http.Transport)
+}
+
+// derivedKey identifies a transport derived by transportFor: the profile it is cloned from,
+// "" for the client's transport, and the per-request settings applied to the clone.
+type derivedKey struct {
+	profile string
+	gen     uint64
+	minTLS  uint16
+	http    int
+}
+
+// profile is a transport registered with RegisterProfile. gen tells registrations under the same
+// name apart, so that clones of a replaced transport are never served.
+type profile struct {
+	tr  *http.Transport
+	gen uint64
+}
+
+// profileGen numbers the profile registrations.
+var profileGen atomic.Uint64
+
+// dropDerived removes the transports derived from the given registration of a profile and
+// closes their idle connections.
+func (client *clientImpl) dropDerived(name string, gen uint64) {
+	client.derived.Range(func(k, v any) bool {
+		if key := k.(derivedKey); key.profile == name && key.gen == gen {
+			client.derived.Delete(k)
+			v.(*http.Transport).CloseIdleConnections()
+		}
+		return true
+	})
+}
+
+// resolveOptions applies a slice of Option functions and returns the resulting options.
+func (client *clientImpl) resolveOptions(opts ...Option) *options {
 	opt := newOptions()
 	for _, fn := range opts {
 		fn(opt)
 	}
-	return opt.Middlewares
+	return opt
 }
 
 // SetMaxIdleConns configures the maximum number of idle connections for the underlying transport.
@@ -324,6 +892,15 @@ func (client *clientImpl) SetMaxIdleConns(maxIdleConn int) Client {
 	return client
 }
 
+// SetMaxConnsPerHost limits the number of connections per host of the underlying transport,
+// in any state: dialing, active or idle.
+func (client *clientImpl) SetMaxConnsPerHost(maxConns int) Client {
+	if maxConns > 0 {
+		client.transport.MaxConnsPerHost = maxConns
+	}
+	return client
+}
+
 // SetIdleConnTimeout configures the idle connection timeout for the underlying transport.
 func (client *clientImpl) SetIdleConnTimeout(idleTimeout time.Duration) Client {
 	if idleTimeout > 0 {
@@ -361,8 +938,66 @@ func DefaultPooledTransport() *http.Transport {
 
 type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
 
+// WithDialer replaces the function used to open plaintext connections. Host pinning and
+// address family preference are still applied on top of it.
 func (client *clientImpl) WithDialer(dialFn DialContextFunc) Client {
-	client.transport.DialContext = dialFn
+	client.dialer.setDial(dialFn)
+	return client
+}
+
+// WithTLSDialer sets the function used to open TLS connections for https requests. It must
+// return a connection whose handshake is done (or done lazily by the connection itself): the
+// transport's TLS settings, such as TLSClientConfig or WithMinTLSVersion, are bypassed, and so
+// are host pinning and address family preference. A nil function restores the default.
+func (client *clientImpl) WithTLSDialer(dialFn DialContextFunc) Client {
+	client.transport.DialTLSContext = dialFn
+	return client
+}
+
+// WithResolvedHost pins host to ip in the dialer, bypassing DNS. The URL and Host header are left untouched.
+func (client *clientImpl) WithResolvedHost(host, ip string) Client {
+	client.dialer.setHost(host, ip)
+	return client
+}
+
+// WithConnDeadlines bounds every read and write on the client's connections, independently of
+// the request timeout: a peer that stays silent for longer than read, or does not accept data
+// for longer than write, fails the request. Zero disables either bound. It applies to
+// connections opened afterwards.
+func (client *clientImpl) WithConnDeadlines(read, write time.Duration) Client {
+	client.dialer.setDeadlines(read, write)
+	return client
+}
+
+// SetHandler serves every request with h in memory instead of sending it, see handlerTransport.
+func (client *clientImpl) SetHandler(h http.Handler) Client {
+	client.handler = h
+	return client
+}
+
+// RegisterProfile registers tr under name, for the requests sent WithProfile(name).
+func (client *clientImpl) RegisterProfile(name string, tr *http.Transport) Client {
+	old, loaded := client.profiles.Swap(name, &profile{tr: tr, gen: profileGen.Add(1)})
+	if loaded {
+		client.dropDerived(name, old.(*profile).gen)
+	}
+	return client
+}
+
+// DialCount returns the number of connections opened so far by the client's dialer.
+func (client *clientImpl) DialCount() int64 {
+	return client.dialer.dials.Load()
+}
+
+// PreferIPv4 makes the dialer try IPv4 addresses of a host before IPv6 ones.
+func (client *clientImpl) PreferIPv4() Client {
+	client.dialer.setFamily(ipv4First)
+	return client
+}
+
+// PreferIPv6 makes the dialer try IPv6 addresses of a host before IPv4 ones.
+func (client *clientImpl) PreferIPv6() Client {
+	client.dialer.setFamily(ipv6First)
 	return client
 }
 
@@ -372,7 +1007,68 @@ var clientPool = sync.Pool{
 	},
 }
 
-func poolGetClient(tr *http.Transport, tm time.Duration) *http.Client {
+// ErrTooManyRedirects is returned (wrapped) when a request exceeds the limit set by SetMaxRedirects.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// ErrRedirectLoop is returned (wrapped) when a redirect leads back, with the same method, to a
+// URL the request already visited twice.
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// defaultMaxRedirects is the limit of http.Client when no CheckRedirect is set.
+const defaultMaxRedirects = 10
+
+// redirectPolicy checks each redirect for loops, then against the redirect limit and the
+// policy set with SetRedirectPolicy. The URL of req is already resolved against the previous
+// one when the Location header is relative. A single return to the same method and URL is
+// allowed, as in Post/Redirect/Get or a redirect setting a cookie and coming back.
+func redirectPolicy(gv *gValue) func(*http.Request, []*http.Request) error {
+	maxRedirects := defaultRedirectsPolicy
+	var policy func(*http.Request, []*http.Request) error
+	if gv != nil {
+		if gv.MaxRedirects != redirectsNotSet {
+			maxRedirects = maxRedirectsPolicy(gv.MaxRedirects)
+		}
+		policy = gv.RedirectPolicy
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		target := req.URL.String()
+		visits := 0
+		for _, prev := range via {
+			if prev.Method == req.Method && prev.URL.String() == target {
+				visits++
+			}
+		}
+		if visits > 1 {
+			return fmt.Errorf("%s %s visited %d times: %w", req.Method, target, visits+1, ErrRedirectLoop)
+		}
+		if err := maxRedirects(req, via); err != nil {
+			return err
+		}
+		if policy != nil {
+			return policy(req, via)
+		}
+		return nil
+	}
+}
+
+// defaultRedirectsPolicy is the limit of http.Client's default policy: it stops after 10 requests.
+func defaultRedirectsPolicy(req *http.Request, via []*http.Request) error {
+	if len(via) >= defaultMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects: %w", defaultMaxRedirects, ErrTooManyRedirects)
+	}
+	return nil
+}
+
+func maxRedirectsPolicy(n int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > n {
+			return fmt.Errorf("stopped after %d redirects: %w", n, ErrTooManyRedirects)
+		}
+		return nil
+	}
+}
+
+func poolGetClient(tr http.RoundTripper, tm time.Duration) *http.Client {
 	c := clientPool.Get().(*http.Client)
 	c.Transport = tr
 	c.CheckRedirect = nil
@@ -9,8 +9,9 @@ import (
 type contextKey string
 
 const (
-	keyContext    = contextKey("http-context")
-	timeoutNotSet = time.Duration(-1)
+	keyContext      = contextKey("http-context")
+	timeoutNotSet   = time.Duration(-1)
+	redirectsNotSet = -1
 )
 
 type gValue struct {
@@ -18,6 +19,36 @@ type gValue struct {
 	Mock        Endpoint
 	Debugger    HTTPLogger
 	RetryOption *RetryOption
+	// MaxRedirects limits the number of redirects followed, redirectsNotSet keeps the stdlib default.
+	MaxRedirects int
+	// RedirectPolicy is called before following each redirect, see SetRedirectPolicy.
+	RedirectPolicy func(req *http.Request, via []*http.Request) error
+	// MinTLSVersion selects a transport that refuses TLS versions below it, 0 means no override.
+	MinTLSVersion uint16
+	// WrapErrors annotates the returned error with the request method and URL.
+	WrapErrors bool
+	// Attempts counts the times the request was actually sent (or served by the mock).
+	Attempts int
+	// Profile selects a transport registered with RegisterProfile, "" means the client's transport.
+	Profile string
+	// HTTPVersion selects a transport for a given protocol major version, 0 means no override.
+	HTTPVersion int
+	// RequireBody makes Unmarshal fail on an empty body, see WithRequireBody.
+	RequireBody bool
+	// Deadline is an absolute deadline for the request, see WithDeadline.
+	Deadline time.Time
+	// Span correlates the request with a trace, see Request.SetSpan.
+	Span string
+	// CaptureBody buffers the request body so that it stays readable once sent, see WithCaptureRequestBody.
+	CaptureBody bool
+	// KeepPartial keeps the .part file of a failed SaveToFile, see WithKeepPartialDownload.
+	KeepPartial bool
+	// ParseProblem decodes problem+json bodies into the status errors, see WithProblemDetails.
+	ParseProblem bool
+	// FromMock is set once the mock endpoint has served the request.
+	FromMock bool
+	// cancel cancels the request context, see Request.Cancel.
+	cancel context.CancelCauseFunc
 }
 
 func getValue(req *http.Request) *gValue {
@@ -32,7 +63,8 @@ func getValue(req *http.Request) *gValue {
 func getOrCreateValue(req *http.Request) *gValue {
 	if gv := getValue(req); gv == nil {
 		gv := &gValue{
-			Timeout: timeoutNotSet,
+			Timeout:      timeoutNotSet,
+			MaxRedirects: redirectsNotSet,
 		}
 		return gv
 	} else {
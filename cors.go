@@ -0,0 +1,105 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures CORSMiddleware.
+type CORSConfig struct {
+	// AllowOrigins lists the origins allowed to call the server, e.g. "https://app.example.com".
+	// "*" allows any origin.
+	AllowOrigins []string
+	// AllowMethods lists the methods allowed in preflight requests, by default
+	// GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowMethods []string
+	// AllowHeaders lists the request headers allowed in preflight requests. When empty, the
+	// headers requested by the browser are allowed.
+	AllowHeaders []string
+	// ExposeHeaders lists the response headers readable by the browser script.
+	ExposeHeaders []string
+	// AllowCredentials lets the browser send cookies and authorization. The matching origin is
+	// then echoed instead of "*", as browsers require.
+	AllowCredentials bool
+	// MaxAge is how long the browser may cache a preflight result, 0 leaves it to the browser.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// EnableCORS adds CORSMiddleware with cfg to the server, see Use.
+func (s *Server) EnableCORS(cfg CORSConfig) {
+	s.Use(CORSMiddleware(cfg))
+}
+
+// CORSMiddleware answers preflight requests (OPTIONS with Access-Control-Request-Method) with a
+// 204 and the Access-Control-Allow-* headers, without reaching the handlers, and adds the CORS
+// headers to the actual responses. Requests without an Origin header are not affected, and
+// preflight requests from an origin that is not allowed are refused with a 403.
+func CORSMiddleware(cfg CORSConfig) ServerMiddleware {
+	methods := cfg.AllowMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(cfg.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(cfg.ExposeHeaders, ", ")
+	anyOrigin := false
+	origins := make(map[string]bool)
+	for _, o := range cfg.AllowOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(o)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if !anyOrigin && !origins[strings.ToLower(origin)] {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if anyOrigin && !cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if exposeHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", exposeHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", allowMethods)
+			if allowHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", allowHeaders)
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			}
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge/time.Second)))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
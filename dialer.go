@@ -0,0 +1,195 @@
+package http
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ipFamily int
+
+const (
+	ipAny ipFamily = iota
+	ipv4First
+	ipv6First
+)
+
+// dialer sits between the transport and the actual dial function. It lets the client
+// change how addresses are resolved (host pinning, address family preference) and swap
+// the underlying dial function without rebuilding the shared transport.
+type dialer struct {
+	mu     sync.RWMutex
+	dial   DialContextFunc
+	hosts  map[string]string
+	family ipFamily
+	// readTimeout and writeTimeout bound each read and write on the dialed connections, see WithConnDeadlines.
+	readTimeout, writeTimeout time.Duration
+	// dials counts the connections successfully opened.
+	dials atomic.Int64
+	// lookups caches the addresses resolved by lookup, by host, see lookupTTL.
+	lookups sync.Map
+}
+
+// lookupTTL is how long lookup reuses the addresses it resolved for a host, so that checking
+// them on every request, see SetNoProxyCIDRs, does not add a DNS query to each.
+const lookupTTL = 30 * time.Second
+
+type cachedLookup struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// lookupIPAddr resolves host names. It is a variable so tests can count the queries.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+func newDialer(dial DialContextFunc) *dialer {
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: defaultConnectTimeout}).DialContext
+	}
+	return &dialer{dial: dial, hosts: make(map[string]string)}
+}
+
+func (d *dialer) setDial(dial DialContextFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dial = dial
+}
+
+func (d *dialer) setHost(host, ip string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts[host] = ip
+}
+
+func (d *dialer) setFamily(f ipFamily) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.family = f
+}
+
+func (d *dialer) setDeadlines(read, write time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.readTimeout, d.writeTimeout = read, write
+}
+
+// DialContext satisfies the transport's DialContext signature.
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.dials.Add(1)
+	d.mu.RLock()
+	read, write := d.readTimeout, d.writeTimeout
+	d.mu.RUnlock()
+	if read > 0 || write > 0 {
+		conn = &deadlineConn{Conn: conn, read: read, write: write}
+	}
+	return conn, nil
+}
+
+// deadlineConn renews the read or write deadline of the connection before each read or write,
+// so that a peer trickling bytes cannot hold it for longer than that between two chunks.
+type deadlineConn struct {
+	net.Conn
+	read, write time.Duration
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if c.read > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.read)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.write > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.write)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (d *dialer) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.RLock()
+	dial, family := d.dial, d.family
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil {
+		if ip, ok := d.hosts[host]; ok {
+			host = ip
+			addr = net.JoinHostPort(ip, port)
+		}
+	}
+	d.mu.RUnlock()
+
+	if err != nil || family == ipAny || net.ParseIP(host) != nil {
+		return dial(ctx, network, addr)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error = &net.AddrError{Err: "no suitable address found", Addr: host}
+	for _, ip := range sortIPs(ips, family) {
+		conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// lookup resolves host the way DialContext would, honoring pinned hosts. Results are reused
+// for lookupTTL.
+func (d *dialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	d.mu.RLock()
+	if ip, ok := d.hosts[host]; ok {
+		host = ip
+	}
+	d.mu.RUnlock()
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	now := time.Now()
+	if v, ok := d.lookups.Load(host); ok {
+		if cached := v.(cachedLookup); now.Before(cached.expires) {
+			return cached.ips, nil
+		}
+		d.lookups.Delete(host)
+	}
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	d.lookups.Store(host, cachedLookup{ips: ips, expires: now.Add(lookupTTL)})
+	return ips, nil
+}
+
+// sortIPs returns the addresses of the preferred family first, keeping the resolver order within each family.
+func sortIPs(ips []net.IPAddr, family ipFamily) []net.IPAddr {
+	preferred := make([]net.IPAddr, 0, len(ips))
+	others := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		isV4 := ip.IP.To4() != nil
+		if isV4 == (family == ipv4First) {
+			preferred = append(preferred, ip)
+		} else {
+			others = append(others, ip)
+		}
+	}
+	return append(preferred, others...)
+}
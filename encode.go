@@ -0,0 +1,94 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Content types set by the Post helpers.
+const (
+	contentTypeJSON = "application/json; charset=utf-8"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// encodeBody encodes body according to contentType, see Client.Send. A string, []byte or
+// io.Reader is taken as already encoded, whatever the content type.
+func encodeBody(body any, contentType string) (io.Reader, error) {
+	switch d := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.NewReader(d), nil
+	case []byte:
+		return bytes.NewBuffer(d), nil
+	case json.RawMessage:
+		return bytes.NewBuffer(d), nil
+	case io.Reader:
+		return d, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(data), nil
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		data, err := xml.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(data), nil
+	case mediaType == contentTypeForm:
+		return encodeForm(body)
+	case mediaType == "application/x-ndjson" || mediaType == "application/jsonl":
+		return encodeNDJSON(body)
+	}
+	return nil, fmt.Errorf("%w %q: cannot encode %T", ErrUnsupportedMediaType, contentType, body)
+}
+
+// encodeForm encodes url.Values verbatim and the values of a map with fmt.Sprint.
+func encodeForm(body any) (io.Reader, error) {
+	var values url.Values
+	switch d := body.(type) {
+	case url.Values:
+		values = d
+	case map[string]string:
+		values = make(url.Values, len(d))
+		for k, v := range d {
+			values.Set(k, v)
+		}
+	case map[string]any:
+		values = make(url.Values, len(d))
+		for k, v := range d {
+			values.Set(k, fmt.Sprint(v))
+		}
+	default:
+		return nil, fmt.Errorf("%w %q: cannot encode %T", ErrUnsupportedMediaType, contentTypeForm, body)
+	}
+	return strings.NewReader(values.Encode()), nil
+}
+
+// encodeNDJSON encodes each element of a slice or array as a JSON document on its own line.
+func encodeNDJSON(body any) (io.Reader, error) {
+	v := reflect.ValueOf(body)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%w %q: cannot encode %T", ErrUnsupportedMediaType, "application/x-ndjson", body)
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
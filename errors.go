@@ -0,0 +1,47 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// IsDNSError reports whether err, e.g. the one of Response.Error, comes from a failed host
+// name resolution: an unknown host or a misbehaving name server.
+func IsDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// IsConnRefused reports whether err comes from a connection refused by the peer, which usually
+// means that nothing listens on the target port: the service is down.
+func IsConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsTLSError reports whether err comes from the TLS handshake: an expired, untrusted or
+// mismatching certificate, a malformed record (e.g. a plain HTTP server), or an alert sent by
+// the peer. Only typed errors are classified, error messages are never inspected.
+func IsTLSError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		unknownCAErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+	)
+	if errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &unknownCAErr) || errors.As(err, &invalidErr) || errors.As(err, &hostnameErr) {
+		return true
+	}
+	// The alerts received over TCP are not an exported type, the connection reports them as a
+	// remote error.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
@@ -2,8 +2,10 @@ package http
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -13,24 +15,68 @@ type Client interface {
 	SetTimeout(tm time.Duration) Client
 	// DisableKeepAlive sets whether to disable HTTP keep-alives.
 	DisableKeepAlive(disable bool) Client
+	// SetBaseURL makes requests with a relative URL (e.g. "/users") resolve against base, whose
+	// path is kept as a prefix. Absolute URLs are left untouched.
+	SetBaseURL(base string) Client
+	// SetMaxRedirects limits how many redirects a request may follow; exceeding it fails the
+	// request with an error wrapping ErrTooManyRedirects. The stdlib default is 10.
+	SetMaxRedirects(n int) Client
+	// SetRedirectPolicy sets a function called before following each redirect, with the same
+	// contract as http.Client.CheckRedirect; req.URL is already resolved when Location is relative.
+	// Independently of it, a redirect coming back a third time to the same method and URL fails
+	// with ErrRedirectLoop.
+	SetRedirectPolicy(fn func(req *http.Request, via []*http.Request) error) Client
+	// SetErrorWrapping makes request errors self-describing, e.g.
+	// "GET https://host/path: dial tcp: connection refused". See RequestError.
+	SetErrorWrapping(enable bool) Client
 	// SetMock sets a mock function to intercept all requests and return a predefined response, primarily for testing.
 	SetMock(fn Endpoint) Client
 	// SetDebug sets a debugger (Logger) to print detailed request and response logs.
 	SetDebug(w HTTPLogger) Client
-	// SetRetry sets the default retry policy for the client.
+	// SetRetry sets the default retry policy for the client, replacing the one of a previous call.
+	// Retry policies never nest: whichever is set last for a request, by SetRetry, RetryMiddleware
+	// or WithRetry, applies alone.
 	SetRetry(opt RetryOption) Client
 	// SetHeader sets a default header that will be sent with all requests.
 	SetHeader(name, val string) Client
 	// SetHeaders sets multiple default headers that will be sent with all requests.
 	SetHeaders(hder map[string]string) Client
+	// SetAccept sets the default Accept header, i.e. the response media type asked for, such as
+	// "application/json". WithAccept overrides it per request.
+	SetAccept(mediaType string) Client
+	// SetUserAgentTemplate sets the default User-Agent to "name/version (go/<go version>; <os>/<arch>)",
+	// e.g. "billing/1.4.2 (go/1.22.1; linux/amd64)". It can be overridden per request with WithHeader.
+	SetUserAgentTemplate(name, version string) Client
+	// SetHost sets the Host presented to the server (the request's Host field) for all requests,
+	// while connections still go to the URL's host. Useful for virtual-host routing or to test
+	// against an IP address with the production domain.
+	SetHost(host string) Client
 	// AddMiddleware appends one or more middlewares to the client. They execute in the order they are added.
 	AddMiddleware(m ...Middleware) Client
+	// AddNamedMiddleware appends a middleware under a name. Adding another one under the same name
+	// replaces it in place instead of running both, and is reported to the package logger (see
+	// SetPackageLogger). SetRetry uses this, so calling it twice keeps a single retry policy.
+	AddNamedMiddleware(name string, m Middleware) Client
 	// PrependMiddleware prepends one or more middlewares to the client. They execute before existing middlewares.
 	PrependMiddleware(m ...Middleware) Client
-	// AddBeforeHook adds a hook function that executes before a request is sent.
+	// AddBeforeHook adds a hook function that executes right before a request is sent, after all
+	// middlewares whenever they were added. Before hooks run in the order they were added.
 	AddBeforeHook(hook func(*http.Request)) Client
-	// AddAfterHook adds a hook function that executes after a successful response is received.
+	// AddAfterHook adds a hook function that executes right after a successful response is received,
+	// before any middleware sees it. After hooks run in the order they were added.
 	AddAfterHook(hook func(*http.Response)) Client
+	// SetHeaderFinalizer sets a function that gets the final say on the request headers: it runs
+	// after all client and request middlewares and before hooks, right before each attempt is
+	// sent (or served by the mock), e.g. to strip hop-by-hop headers or enforce a required one.
+	SetHeaderFinalizer(fn func(http.Header)) Client
+	// EnableRecorder writes every exchange of the client to w in a replayable format, see
+	// RecorderMiddleware and LoadRecording. Calling it again replaces the previous writer.
+	EnableRecorder(w io.Writer) Client
+	// AddResponseInterceptor adds a function that runs on every successful response and, unlike an
+	// after hook, can replace it or return an error, which fails the request (see Response.Error).
+	// An interceptor that replaces the response must close the original body itself; an error
+	// returned with a nil response closes it automatically.
+	AddResponseInterceptor(fn func(*http.Response) (*http.Response, error)) Client
 	// MakeDoer creates a Doer based on the provided options. A Doer is a function that can execute an http.Request, useful for integration with other libraries.
 	MakeDoer(opts ...Option) Doer
 	// DoRequest executes a pre-created http.Request using the client's configuration and specified options.
@@ -50,19 +96,58 @@ type Client interface {
 	//    options to override client-wide defaults.
 	//
 	// The final step is the actual HTTP request execution, which is also wrapped by internal middlewares that apply timeout, retry, and logging logic based on the configuration accumulated from the previous middleware layers.
+	//
+	// Request Body:
+	// When a mock or retries are configured, the request body is buffered into a repeatable reader
+	// once, before the mock, logging and retry layers run. The mock and every attempt on the
+	// transport see the full body from its beginning, no matter which middleware read it before
+	// through RepeatableReadRequest.
 	Do(ctx context.Context, method string, uri string, body io.Reader, opts ...Option) *Response
+	// Prepare parses a URL template with {param} placeholders and resolves opts once, for hot
+	// paths that send the same request shape with varying path parameters. See PreparedRequest.
+	Prepare(method, urlTemplate string, opts ...Option) *PreparedRequest
+	// Async executes the request in the background and returns a channel that delivers the single
+	// *Response once it is done, which makes fan-out with select straightforward.
+	// The caller still owns the response and must consume its body (e.g. via Error, GetBody or Save).
+	Async(ctx context.Context, method string, uri string, body io.Reader, opts ...Option) <-chan *Response
+	// Stream executes the request in the background and emits each top-level JSON value of the
+	// response body (array elements or concatenated values) as it arrives, for long-lived feeds.
+	// The error channel then delivers one error, nil on success, and both channels are closed.
+	// Canceling ctx stops the stream. No timeout applies unless given in opts.
+	Stream(ctx context.Context, method string, uri string, body io.Reader, opts ...Option) (<-chan json.RawMessage, <-chan error)
 	// Download is a convenience method for downloading a resource and writing its content to an io.Writer.
 	Download(ctx context.Context, uri string, w io.Writer, opts ...Option) error
+	// DownloadToFile downloads a resource into the file at path, creating parent directories as needed.
+	// The body is written to path+".part" and renamed once complete; the partial file is removed if
+	// the request or the copy fails, context cancellation included, unless WithKeepPartialDownload is set.
+	DownloadToFile(ctx context.Context, uri string, path string, opts ...Option) error
 	// Get is a convenience method for executing a GET request.
 	Get(ctx context.Context, uri string, opts ...Option) *Response
+	// GetBytes executes a GET request and returns the whole body along with the response, whose
+	// body is already drained and closed. The response is nil if none was received.
+	GetBytes(ctx context.Context, uri string, opts ...Option) ([]byte, *http.Response, error)
+	// Head is a convenience method for executing a HEAD request. The response has no body, use
+	// Response.ContentLength, Response.LastModified or the Header to inspect it.
+	Head(ctx context.Context, uri string, opts ...Option) *Response
+	// Ping reports whether uri is reachable and healthy: it returns nil only on a 2xx answer to a
+	// HEAD request, or to a GET if HEAD is not allowed. It uses a 5 second timeout by default.
+	Ping(ctx context.Context, uri string, opts ...Option) error
+	// Options is a convenience method for executing an OPTIONS request.
+	Options(ctx context.Context, uri string, opts ...Option) *Response
 	// Post is a convenience method for executing a POST request with an io.Reader body.
 	Post(ctx context.Context, urlstr string, data io.Reader, opts ...Option) *Response
+	// PostFile is a convenience method for executing a POST request that streams the file at path
+	// as the body, with Content-Type derived from its extension. See WithBodyFile.
+	PostFile(ctx context.Context, urlstr string, path string, opts ...Option) *Response
 	// Delete is a convenience method for executing a DELETE request with an io.Reader body.
 	Delete(ctx context.Context, urlstr string, data io.Reader, opts ...Option) *Response
 	// Put is a convenience method for executing a PUT request with an io.Reader body.
 	Put(ctx context.Context, urlstr string, data io.Reader, opts ...Option) *Response
 	// PostForm is a convenience method for sending a POST request with "application/x-www-form-urlencoded" format.
 	PostForm(ctx context.Context, urlstr string, data map[string]any, opts ...Option) *Response
+	// PostFormValues is like PostForm but sends pre-built url.Values verbatim, so that keys with
+	// several values are preserved.
+	PostFormValues(ctx context.Context, urlstr string, values url.Values, opts ...Option) *Response
 	// PostJSON is a convenience method for sending a POST request with a JSON body.
 	// It automatically sets the "Content-Type" header to "application/json; charset=utf-8".
 	// The `data` parameter can be of various types:
@@ -71,8 +156,71 @@ type Client interface {
 	//   - An io.Reader: The stream's content will be sent as the request body.
 	//   - nil: An empty request body will be sent.
 	PostJSON(ctx context.Context, urlstr string, data any, opts ...Option) *Response
+	// Send executes a request whose body is encoded according to contentType, which also becomes
+	// the Content-Type header:
+	//   - JSON (application/json, or any +json type): body is marshaled with json.Marshal.
+	//   - XML (application/xml, text/xml, or any +xml type): body is marshaled with xml.Marshal.
+	//   - Form (application/x-www-form-urlencoded): body is a url.Values, sent verbatim, or a
+	//     map[string]string or map[string]any, whose values are formatted with fmt.Sprint.
+	//   - NDJSON (application/x-ndjson, application/jsonl): body is a slice, each element is
+	//     sent as a JSON document on its own line.
+	// A string, []byte, json.RawMessage or io.Reader is sent as is, whatever the content type,
+	// and a nil body sends no body. Other combinations fail with ErrUnsupportedMediaType.
+	// PostJSON, PostForm and PostFormValues are shorthands for it.
+	Send(ctx context.Context, method string, uri string, body any, contentType string, opts ...Option) *Response
+	// PostJSONBytes is like PostJSON but returns the whole body along with the response, see GetBytes.
+	PostJSONBytes(ctx context.Context, urlstr string, data any, opts ...Option) ([]byte, *http.Response, error)
+	// PostBytes is like Post but returns the whole body along with the response, see GetBytes.
+	PostBytes(ctx context.Context, urlstr string, data io.Reader, opts ...Option) ([]byte, *http.Response, error)
+	// PutBytes is like Put but returns the whole body along with the response, see GetBytes.
+	PutBytes(ctx context.Context, urlstr string, data io.Reader, opts ...Option) ([]byte, *http.Response, error)
+	// DeleteBytes is like Delete but returns the whole body along with the response, see GetBytes.
+	DeleteBytes(ctx context.Context, urlstr string, data io.Reader, opts ...Option) ([]byte, *http.Response, error)
+	// PostFormBytes is like PostForm but returns the whole body along with the response, see GetBytes.
+	PostFormBytes(ctx context.Context, urlstr string, data map[string]any, opts ...Option) ([]byte, *http.Response, error)
+	// SendBytes is like Send but returns the whole body along with the response, see GetBytes.
+	SendBytes(ctx context.Context, method string, uri string, body any, contentType string, opts ...Option) ([]byte, *http.Response, error)
+	// GRPCCall performs a unary gRPC call over HTTP/2 through the client's transport and
+	// middlewares. Messages are encoded with DefaultGRPCCodec. A non-zero grpc-status is
+	// returned as a *GRPCError.
+	GRPCCall(ctx context.Context, fullMethod string, req, resp any, opts ...Option) error
 	// WithDialer allows setting a custom dialer function for the client's Transport.
 	WithDialer(dialFn DialContextFunc) Client
+	// WithTLSDialer sets a custom function opening TLS connections (custom TLS stack, SNI or
+	// fingerprint control). It bypasses the transport's own TLS configuration.
+	WithTLSDialer(dialFn DialContextFunc) Client
+	// WithResolvedHost overrides DNS for host so that connections go to ip, while the URL and
+	// Host header stay unchanged. Useful for testing against a canary instance.
+	WithResolvedHost(host, ip string) Client
+	// WithConnDeadlines bounds each read and write on the client's connections, e.g. against a peer
+	// trickling bytes within the overall timeout. Since the read deadline also runs while a
+	// keep-alive connection sits idle in the pool, idle connections are closed after read.
+	// Connections opened by a WithTLSDialer function are not affected. Zero disables either bound.
+	WithConnDeadlines(read, write time.Duration) Client
+	// SetNoProxyCIDRs bypasses the proxy for destinations whose (resolved) address falls in one
+	// of the given CIDR ranges, e.g. internal hosts resolving to private addresses.
+	SetNoProxyCIDRs(cidrs []string) Client
+	// SetHandler routes every request into h in process, without opening a socket, to test a
+	// client against a real handler (e.g. the server's mux). Unlike SetMock, the request goes
+	// through the whole client: middlewares, retries, redirects and timeouts apply. The handler
+	// replaces all transports, including profiles; nil restores them. Clients forked afterwards
+	// keep it.
+	SetHandler(h http.Handler) Client
+	// RegisterProfile registers a transport under a name, so that requests sent WithProfile(name)
+	// use it (its TLS, proxy and connection settings) while sharing the client's middlewares.
+	// The profile transport is used as is: the client's dialer settings (WithResolvedHost,
+	// PreferIPv4, DialCount, ...) do not apply to it. Registering a name again replaces its
+	// transport. Forked clients share the profiles.
+	RegisterProfile(name string, tr *http.Transport) Client
+	// DialCount returns how many connections the client has opened, which lets tests assert that
+	// keep-alive connections are reused: it stays constant across requests served by pooled
+	// connections. Forked clients share the counter, and connections opened by a WithTLSDialer
+	// function are not counted.
+	DialCount() int64
+	// PreferIPv4 makes the dialer try a host's IPv4 addresses first, falling back to IPv6.
+	PreferIPv4() Client
+	// PreferIPv6 makes the dialer try a host's IPv6 addresses first, falling back to IPv4.
+	PreferIPv6() Client
 	// Fork creates a new "child" client instance that shares the parent's underlying
 	// http.Transport. This is highly efficient as it allows connection pooling and reuse
 	// across multiple, logically distinct clients.
@@ -88,6 +236,11 @@ type Client interface {
 	Fork(withMiddlewares bool) Client
 	// SetMaxIdleConns sets the maximum number of idle connections for the Transport.
 	SetMaxIdleConns(maxIdleConn int) Client
+	// SetMaxConnsPerHost caps the total number of connections per host of the Transport, not
+	// only the idle ones, e.g. to avoid overwhelming a single upstream. Requests over the limit
+	// wait for a connection, and fail with ErrConnPoolTimeout if none frees up in time.
+	// Non-positive values are ignored.
+	SetMaxConnsPerHost(maxConns int) Client
 	// SetIdleConnTimeout sets the idle connection timeout for the Transport.
 	SetIdleConnTimeout(idleTimeout time.Duration) Client
 }
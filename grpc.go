@@ -0,0 +1,131 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// GRPCCodec encodes and decodes gRPC messages. The package does not depend on protobuf: the
+// default codec handles messages with Marshal/Unmarshal methods (as generated by gogoproto or
+// vtprotobuf). For google.golang.org/protobuf messages, set DefaultGRPCCodec to a codec based
+// on proto.Marshal and proto.Unmarshal.
+type GRPCCodec interface {
+	// Name is the content subtype, the request is sent as "application/grpc+<name>".
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// DefaultGRPCCodec is the codec used by GRPCCall.
+var DefaultGRPCCodec GRPCCodec = marshalerCodec{}
+
+type marshalerCodec struct{}
+
+func (marshalerCodec) Name() string { return "proto" }
+
+func (marshalerCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(interface{ Marshal() ([]byte, error) }); ok {
+		return m.Marshal()
+	}
+	return nil, fmt.Errorf("grpc: %T does not implement Marshal() ([]byte, error)", v)
+}
+
+func (marshalerCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(interface{ Unmarshal([]byte) error }); ok {
+		return m.Unmarshal(data)
+	}
+	return fmt.Errorf("grpc: %T does not implement Unmarshal([]byte) error", v)
+}
+
+// GRPCError is returned by GRPCCall when the server replies with a non-zero grpc-status.
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *GRPCError) Error() string {
+	return fmt.Sprintf("grpc error: code = %d desc = %s", e.Code, e.Message)
+}
+
+// ErrGRPCProtocol is wrapped by errors caused by a malformed gRPC response.
+var ErrGRPCProtocol = errors.New("grpc protocol error")
+
+// GRPCCall performs a unary gRPC call over HTTP/2, going through the client's transport and
+// middlewares so retries, timeouts and tracing apply as for any request. fullMethod is either
+// "/package.Service/Method", resolved with SetBaseURL, or a full https URL.
+func (client *clientImpl) GRPCCall(ctx context.Context, fullMethod string, req, resp any, opts ...Option) error {
+	codec := DefaultGRPCCodec
+	payload, err := codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+
+	opts = append([]Option{
+		WithHeaders(map[string]string{
+			"Content-Type": "application/grpc+" + codec.Name(),
+			"TE":           "trailers",
+		}),
+		withHTTPVersion(2),
+	}, opts...)
+	return client.Do(ctx, "POST", fullMethod, bytes.NewReader(frame), opts...).HandleResult(func(res *http.Response) error {
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w: unexpected http status %s", ErrGRPCProtocol, res.Status)
+		}
+		var data []byte
+		if res.Body != nil {
+			header := make([]byte, 5)
+			if _, err := io.ReadFull(res.Body, header); err == nil {
+				if header[0] != 0 {
+					return fmt.Errorf("%w: compressed messages are not supported", ErrGRPCProtocol)
+				}
+				data = make([]byte, binary.BigEndian.Uint32(header[1:]))
+				if _, err := io.ReadFull(res.Body, data); err != nil {
+					return fmt.Errorf("%w: %v", ErrGRPCProtocol, err)
+				}
+			} else if err != io.EOF {
+				return fmt.Errorf("%w: %v", ErrGRPCProtocol, err)
+			}
+			// Trailers are only populated once the body is read to EOF.
+			io.Copy(io.Discard, res.Body)
+		}
+		if err := grpcStatus(res); err != nil {
+			return err
+		}
+		if data == nil {
+			return fmt.Errorf("%w: missing response message", ErrGRPCProtocol)
+		}
+		return codec.Unmarshal(data, resp)
+	})
+}
+
+// grpcStatus reads the status from the trailers, or from the headers of a trailers-only response.
+func grpcStatus(res *http.Response) error {
+	status, msg := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		return fmt.Errorf("%w: missing grpc-status", ErrGRPCProtocol)
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("%w: invalid grpc-status %q", ErrGRPCProtocol, status)
+	}
+	if code != 0 {
+		if m, err := url.PathUnescape(msg); err == nil {
+			msg = m
+		}
+		return &GRPCError{Code: code, Message: msg}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+)
+
+// handlerTransport is a RoundTripper that serves requests with a handler in memory, through an
+// httptest.ResponseRecorder: no socket is opened.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip turns req into the server-side request a handler expects and returns what the
+// handler wrote. The response is complete once the handler returns, so a streaming handler is
+// only seen at the end. A panicking handler fails the request, like a server dropping the
+// connection.
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sreq := req.Clone(req.Context())
+	sreq.RequestURI = req.URL.RequestURI()
+	sreq.RemoteAddr = "127.0.0.1:0"
+	if sreq.Host == "" {
+		sreq.Host = req.URL.Host
+	}
+	if sreq.Body == nil {
+		sreq.Body = http.NoBody
+	}
+	if sreq.Proto == "" {
+		sreq.Proto, sreq.ProtoMajor, sreq.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+
+	rec := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("http: panic serving %s %s: %v", req.Method, req.URL, p)
+			}
+		}()
+		t.handler.ServeHTTP(rec, sreq)
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	res := rec.Result()
+	res.Request = req
+	return res, nil
+}
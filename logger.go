@@ -0,0 +1,31 @@
+package http
+
+import "sync/atomic"
+
+// Logger receives the package's internal diagnostics, such as retries and their backoff or
+// failed shadow requests. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// loggerHolder lets a Logger of any concrete type be stored atomically.
+type loggerHolder struct{ Logger }
+
+var packageLogger atomic.Pointer[loggerHolder]
+
+// SetPackageLogger sends the package's internal diagnostics to l. They are discarded by default,
+// and again once l is nil. It is safe to call while requests are in flight.
+func SetPackageLogger(l Logger) {
+	if l == nil {
+		packageLogger.Store(nil)
+		return
+	}
+	packageLogger.Store(&loggerHolder{Logger: l})
+}
+
+// logf writes an internal diagnostic to the package logger.
+func logf(format string, args ...any) {
+	if h := packageLogger.Load(); h != nil {
+		h.Printf(format, args...)
+	}
+}
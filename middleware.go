@@ -1,13 +1,18 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
-	"sync"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,39 +22,296 @@ type Middleware func(Endpoint) Endpoint
 
 func middlewareInitCtx(next Endpoint) Endpoint {
 	return func(req *http.Request) (*http.Response, error) {
-		req = setValue(req, getOrCreateValue(req))
+		gv := getOrCreateValue(req)
+		req = setValue(req, gv)
+		/*
+		 * The cancelable context of Request.Cancel. A request sent from within another one shares
+		 * its value, the outer cancel func is restored once the inner request is done.
+		 */
+		parent := req.Context()
+		ctx, cancel := context.WithCancelCause(parent)
+		defer func(prev context.CancelCauseFunc) { gv.cancel = prev }(gv.cancel)
+		gv.cancel = cancel
+		req = req.WithContext(ctx)
+		res, err := next(req)
+		if err != nil && ctx.Err() != nil {
+			if cause := context.Cause(ctx); !errors.Is(err, cause) {
+				err = fmt.Errorf("%w: %w", cause, err)
+			}
+		}
+		switch {
+		case err != nil || res == nil || res.Body == nil:
+			cancel(nil)
+		case parent.Done() != nil:
+			// The context stays registered with its parent until canceled, the body may still be
+			// read after the request returned: release it once the body is closed.
+			res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: func() { cancel(nil) }}
+		}
+		if err != nil && gv.WrapErrors {
+			err = &RequestError{Method: req.Method, URL: req.URL.String(), Err: err}
+		}
+		return res, err
+	}
+}
+
+// RequestError annotates an error with the request it belongs to, see SetErrorWrapping.
+type RequestError struct {
+	Method string
+	URL    string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	msg := e.Err
+	// A *url.Error already names the method and URL, only keep its cause.
+	if ue, ok := e.Err.(*url.Error); ok {
+		msg = ue.Err
+	}
+	return fmt.Sprintf("%s %s: %v", e.Method, e.URL, msg)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError reports a response rejected because of its status code, see
+// MiddlewareCheckStatusCode and WithErrorDecoder.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Body       []byte
+	// Decoded holds the body decoded by WithErrorDecoder, or the *ProblemDetails of an
+	// application/problem+json body. It is nil if the body could not be decoded.
+	Decoded any
+
+	// bodyLimit caps the body quoted by Error. Zero quotes the whole body, so that a StatusError
+	// built by hand prints it, and statusBodyHidden omits it.
+	bodyLimit int
+}
+
+// statusBodyHidden is the StatusError.bodyLimit that leaves the body out of the message.
+const statusBodyHidden = -1
+
+func (e *StatusError) Error() string {
+	body := e.Body
+	switch {
+	case e.bodyLimit == statusBodyHidden:
+		return fmt.Sprintf("%s %s %s", e.Method, e.URL, e.Status)
+	case e.bodyLimit > 0 && len(body) > e.bodyLimit:
+		return fmt.Sprintf("%s %s %s %s...(%d bytes truncated)", e.Method, e.URL, e.Status, body[:e.bodyLimit], len(body)-e.bodyLimit)
+	}
+	return fmt.Sprintf("%s %s %s %s", e.Method, e.URL, e.Status, body)
+}
+
+// Unwrap exposes Decoded when it is itself an error, so that errors.As can reach it.
+func (e *StatusError) Unwrap() error {
+	if err, ok := e.Decoded.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// newStatusError builds the error for a rejected response. With WithProblemDetails, an RFC 7807
+// problem body is decoded into Decoded, so that errors.As reaches the *ProblemDetails.
+func newStatusError(req *http.Request, resp *http.Response, body []byte) *StatusError {
+	e := &StatusError{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       body,
+	}
+	if gv := getValue(req); gv != nil && gv.ParseProblem {
+		if problem := parseProblem(resp, body); problem != nil {
+			e.Decoded = problem
+		}
+	}
+	return e
+}
+
+// clientHooks are the hooks added with AddBeforeHook and AddAfterHook, and the header
+// finalizer set with SetHeaderFinalizer.
+type clientHooks struct {
+	before    []func(*http.Request)
+	after     []func(*http.Response)
+	finalizer func(http.Header)
+}
+
+func (hooks clientHooks) clone() clientHooks {
+	return clientHooks{
+		before:    slices.Clone(hooks.before),
+		after:     slices.Clone(hooks.after),
+		finalizer: hooks.finalizer,
+	}
+}
+
+func (hooks clientHooks) empty() bool {
+	return len(hooks.before) == 0 && len(hooks.after) == 0 && hooks.finalizer == nil
+}
+
+// finalize runs the header finalizer right before next, i.e. before each attempt.
+func (hooks clientHooks) finalize(next Endpoint) Endpoint {
+	if hooks.finalizer == nil {
+		return next
+	}
+	return func(req *http.Request) (*http.Response, error) {
+		hooks.finalizer(req.Header)
 		return next(req)
 	}
 }
 
-func middlewareContext(next Endpoint) Endpoint {
+// wrap runs the before hooks right before next and the after hooks on its successful response.
+func (hooks clientHooks) wrap(next Endpoint) Endpoint {
+	if len(hooks.before) == 0 && len(hooks.after) == 0 {
+		return next
+	}
+	return func(req *http.Request) (*http.Response, error) {
+		for _, hook := range hooks.before {
+			hook(req)
+		}
+		res, err := next(req)
+		if err == nil && res != nil {
+			for _, hook := range hooks.after {
+				hook(res)
+			}
+		}
+		return res, err
+	}
+}
+
+// middlewareContext applies the settings gathered by the middlewares in the request value. The
+// client hooks run innermost of the middlewares, once per request whatever the retries.
+func middlewareContext(next Endpoint, hooks clientHooks) Endpoint {
 	return func(req *http.Request) (*http.Response, error) {
 		gv := getValue(req)
 		if gv == nil {
 			return next(req)
 		}
+		/* build on a local copy, next is shared by every request of a Doer */
+		h := next
+
+		/* absolute deadline, see WithDeadline */
+		if !gv.Deadline.IsZero() {
+			remaining := time.Until(gv.Deadline)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("deadline %s already passed: %w", gv.Deadline.Format(time.RFC3339Nano), context.DeadlineExceeded)
+			}
+			if gv.Timeout == timeoutNotSet || gv.Timeout <= 0 || gv.Timeout > remaining {
+				gv.Timeout = remaining
+			}
+		}
+
+		/* time budget shared with the caller, see WithBudget */
+		if err := applyBudget(req.Context(), gv); err != nil {
+			return nil, err
+		}
+
+		/*
+		 * With a mock or retries the body may be read more than once, buffer it into a
+		 * repeatable reader once, up front. Every reader (middlewares calling
+		 * RepeatableReadRequest, the mock, each attempt on the transport) then starts from the
+		 * beginning of the body. A file body (WithBodyFile) is seekable and rewound instead.
+		 * The same applies when the body must stay readable after the send, for after hooks.
+		 * RetryOption.NoBodyBuffer opts out of buffering for retries, see middlewareRetry.
+		 */
+		if req.Body != nil && req.Body != http.NoBody && !isRewindable(req.Body) && (gv.Mock != nil || gv.CaptureBody || (gv.RetryOption != nil && gv.RetryOption.RetryMax > 0 && !gv.RetryOption.NoBodyBuffer)) {
+			if _, err := RepeatableReadRequest(req); err != nil {
+				return nil, err
+			}
+		}
 
 		/* mock */
 		if gv.Mock != nil {
-			next = middlewareSetMock(gv.Mock)(next)
+			h = middlewareSetMock(gv.Mock)(h)
 		}
 
+		/* header finalizer, last to touch the request */
+		h = hooks.finalize(h)
+
+		/* count attempts */
+		h = middlewareCountAttempts(h)
+
 		/* log */
 		if gv.Debugger != nil {
-			next = middlewareDebug(gv.Debugger)(next)
+			h = middlewareDebug(gv.Debugger)(h)
 		}
 
 		/* retry */
 		if gv.RetryOption != nil && gv.RetryOption.RetryMax > 0 {
-			next = middlewareRetry(gv.RetryOption)(next)
+			h = middlewareRetry(gv.RetryOption)(h)
 		}
-		return next(req)
+
+		/* client hooks */
+		h = hooks.wrap(h)
+		return h(req)
 	}
 }
 
 func middlewareSetMock(fn func(*http.Request) (*http.Response, error)) Middleware {
 	return func(next Endpoint) Endpoint {
-		return fn
+		return func(req *http.Request) (*http.Response, error) {
+			gv := getValue(req)
+			gv.FromMock = true
+			rewindRequestBody(req)
+			/* same timeout as a real round trip, see send */
+			timeout := defaultConnectTimeout
+			if gv.Timeout != timeoutNotSet {
+				timeout = gv.Timeout
+			}
+			if timeout <= 0 {
+				return fn(req)
+			}
+			return callMockWithTimeout(fn, req, timeout)
+		}
+	}
+}
+
+// callMockWithTimeout runs the mock under a context deadline and gives up on it once the deadline
+// passes, even if the mock ignores its context. The deadline stays in force while the response
+// body is read, like the Timeout of an http.Client.
+func callMockWithTimeout(fn Endpoint, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	type result struct {
+		res *http.Response
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := fn(req.WithContext(ctx))
+		done <- result{res: res, err: err}
+	}()
+	select {
+	case r := <-done:
+		if r.res == nil || r.res.Body == nil {
+			cancel()
+			return r.res, r.err
+		}
+		r.res.Body = &cancelOnClose{ReadCloser: r.res.Body, cancel: cancel}
+		return r.res, r.err
+	case <-ctx.Done():
+		cancel()
+		return nil, fmt.Errorf("mock %s %s: %w", req.Method, req.URL, ctx.Err())
+	}
+}
+
+// cancelOnClose releases the context of a mocked request once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func middlewareCountAttempts(next Endpoint) Endpoint {
+	return func(req *http.Request) (*http.Response, error) {
+		getValue(req).Attempts++
+		return next(req)
 	}
 }
 
@@ -86,6 +348,8 @@ type TransportInfo struct {
 	Err      error
 	Request  *TransportEntity
 	Response *TransportEntity
+	// Span is the correlation identifier set with Request.SetSpan.
+	Span string
 }
 
 var DefaultLogger = BuildLogger(func() bool { return true }, defaultLogger)
@@ -102,6 +366,9 @@ func defaultLogger(ctx context.Context, info *TransportInfo) {
 		info.StartAt.Format("2006-01-02 15:04:05.000"),
 		info.Cost,
 	)
+	if info.Span != "" {
+		fmt.Fprintf(w, "[Span] %s\n", info.Span)
+	}
 	/* request */
 	fmt.Fprintln(w, "[Request-Headers]")
 	for k := range info.Request.Header {
@@ -160,6 +427,7 @@ func middlewareDebug(loggerFn HTTPLogger) Middleware {
 					return resBody
 				}
 			}
+			info.Span = FromRequest(req).Span()
 			loggerFn.Log(req.Context(), info)
 			return res, err
 		}
@@ -182,33 +450,98 @@ func middlewareRetry(retryOpt *RetryOption) Middleware {
 	if retryOpt.RetryWaitMax <= 0 {
 		retryOpt.RetryWaitMax = 3 * time.Second
 	}
-	shouldRetry := func(res *http.Response, err error) bool {
+	shouldRetry := func(res *http.Response, err error, attempt int) bool {
 		return err != nil
 	}
-	if retryOpt.CheckResponse != nil {
-		shouldRetry = retryOpt.CheckResponse
+	if retryOpt.CheckResponseWithAttempt != nil {
+		check := retryOpt.CheckResponseWithAttempt
+		shouldRetry = func(res *http.Response, err error, attempt int) bool {
+			return check(res, err, attempt)
+		}
+	} else if retryOpt.CheckResponse != nil {
+		check := retryOpt.CheckResponse
+		shouldRetry = func(res *http.Response, err error, attempt int) bool {
+			return check(res, err)
+		}
+	}
+	if len(retryOpt.RetryStatuses) > 0 {
+		statuses := make(map[int]bool)
+		for _, code := range retryOpt.RetryStatuses {
+			statuses[code] = true
+		}
+		check := shouldRetry
+		shouldRetry = func(res *http.Response, err error, attempt int) bool {
+			if err == nil && res != nil && statuses[res.StatusCode] {
+				return true
+			}
+			return check(res, err, attempt)
+		}
 	}
 	return func(next Endpoint) Endpoint {
 		return func(req *http.Request) (res *http.Response, err error) {
-			for i := 0; i < retryOpt.RetryMax+1; i++ {
-				/* save request body */
-				if req.Body != nil {
-					if _, err := RepeatableReadRequest(req); err != nil {
+			/*
+			 * The request timeout bounds the whole retry sequence while PerAttemptTimeout bounds
+			 * each attempt. Each attempt runs with the smaller of the two, applied through the
+			 * per-request Timeout that the pooled client honors.
+			 */
+			gv := getValue(req)
+			deadline := requestDeadline(req, gv)
+			defer func(tm time.Duration) { gv.Timeout = tm }(gv.Timeout)
+			attempts := retryOpt.RetryMax + 1
+			streamed := retryOpt.NoBodyBuffer && req.Body != nil && req.Body != http.NoBody && !isRewindable(req.Body)
+			if streamed {
+				/* the body can only be sent once and must not be buffered: no retry */
+				attempts = 1
+			}
+			for i := 0; i < attempts; i++ {
+				/* let the caller rewrite the request, e.g. a fresh nonce in the body */
+				if retryOpt.ModifyRequest != nil {
+					if err := retryOpt.ModifyRequest(req, i); err != nil {
 						return nil, err
 					}
 				}
 
+				/* save request body, a file is rewound rather than buffered */
+				if _, ok := req.Body.(*repeatableReader); !ok && isRewindable(req.Body) {
+					if err := rewindRequestBody(req); err != nil {
+						return nil, err
+					}
+				} else if req.Body != nil && !streamed {
+					data, err := RepeatableReadRequest(req)
+					if err != nil {
+						return nil, err
+					}
+					if retryOpt.ModifyRequest != nil && req.Body != http.NoBody {
+						/* the body may have been replaced, keep ContentLength in line */
+						setRequestBody(req, data)
+					}
+				}
+
+				/* per attempt timeout */
+				if tm, ok := attemptTimeout(retryOpt.PerAttemptTimeout, deadline); ok {
+					gv.Timeout = tm
+				}
+
 				/* do request */
 				res, err = next(req)
-				if !shouldRetry(res, err) {
+				if !checkRetry(shouldRetry, retryOpt.PeekBytes, res, err, i) {
 					break
 				}
 
 				if res != nil && res.Body != nil {
 					drainBody(res.Body)
 				}
-				if i < retryOpt.RetryMax {
-					time.Sleep(linearJitterBackoff(retryOpt.RetryWaitMin, retryOpt.RetryWaitMax, i))
+				if i < attempts-1 {
+					wait := retryWait(retryOpt, res, i)
+					if !deadline.IsZero() && time.Until(deadline) <= wait {
+						/* no time left for another attempt */
+						logf("http: %s %s: no time left for attempt %d after %s", req.Method, req.URL, i+2, wait)
+						break
+					}
+					logf("http: %s %s: attempt %d failed (%s), retrying in %s", req.Method, req.URL, i+1, retryCause(res, err), wait)
+					if err := timeSleep(req.Context(), wait); err != nil {
+						return nil, err
+					}
 				}
 			}
 			return
@@ -216,19 +549,149 @@ func middlewareRetry(retryOpt *RetryOption) Middleware {
 	}
 }
 
+// checkRetry calls shouldRetry, with the response body limited to its first peek bytes if peek
+// is positive. The body is restored in full afterwards.
+func checkRetry(shouldRetry func(*http.Response, error, int) bool, peek int, res *http.Response, err error, attempt int) bool {
+	if peek <= 0 || err != nil || res == nil || res.Body == nil || res.Body == http.NoBody {
+		return shouldRetry(res, err, attempt)
+	}
+	body := res.Body
+	data, _ := io.ReadAll(io.LimitReader(body, int64(peek)))
+	res.Body = newRepeatableReader(data)
+	retry := shouldRetry(res, err, attempt)
+	res.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(data), body), Closer: body}
+	return retry
+}
+
+// peekedBody is a response body whose beginning was read ahead, it reads the peeked bytes first.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// retryCause describes why an attempt is retried, for diagnostics.
+func retryCause(res *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if res != nil {
+		return res.Status
+	}
+	return "no response"
+}
+
+// retryWait returns how long to wait before the attempt following attempt i, whose response
+// was res: a Retry-After hint or BackoffByStatus for the listed statuses, the default backoff
+// otherwise.
+func retryWait(retryOpt *RetryOption, res *http.Response, i int) time.Duration {
+	if res != nil {
+		if wait, ok := retryOpt.BackoffByStatus[res.StatusCode]; ok {
+			if hint, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+				return hint
+			}
+			return wait
+		}
+	}
+	return linearJitterBackoff(retryOpt.RetryWaitMin, retryOpt.RetryWaitMax, i, retryOpt.jitter)
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if tm, err := http.ParseTime(v); err == nil {
+		if wait := time.Until(tm); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// IsRetryableError reports whether err is a transient connection failure that a new attempt
+// usually fixes, such as an HTTP/2 GOAWAY sent during a deploy, a connection reset by the peer
+// or an idle keep-alive connection closed by the server. The retry middleware retries them by
+// default, like any error; a CheckResponse is authoritative and may call it to do the same.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{
+		"http2: server sent GOAWAY",
+		"http2: client connection lost",
+		"http: server closed idle connection",
+		"connection reset by peer",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestDeadline returns the time by which the request must be done: the configured timeout
+// from now, or the deadline of the request context if it is earlier. A context deadline set by
+// the caller, e.g. on a request handed to a Doer, is never extended by WithTimeout. A zero
+// timeout means none, like for the pooled client. The zero time means no deadline.
+func requestDeadline(req *http.Request, gv *gValue) time.Time {
+	var deadline time.Time
+	if gv.Timeout != timeoutNotSet && gv.Timeout > 0 {
+		deadline = time.Now().Add(gv.Timeout)
+	}
+	if ctxDeadline, ok := req.Context().Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
+}
+
+// attemptTimeout returns the timeout of a single attempt given the per-attempt limit and the
+// deadline of the whole sequence, either of which may be unset.
+func attemptTimeout(perAttempt time.Duration, deadline time.Time) (time.Duration, bool) {
+	if deadline.IsZero() {
+		return perAttempt, perAttempt > 0
+	}
+	remaining := time.Until(deadline)
+	if perAttempt > 0 && perAttempt < remaining {
+		return perAttempt, true
+	}
+	if remaining <= 0 {
+		// A zero Timeout means no timeout for the pooled client, keep the attempt bounded.
+		remaining = time.Nanosecond
+	}
+	return remaining, true
+}
+
+// timeSleep waits for d, returning early with the context error if ctx is done first.
+// It is a variable so tests can replace the backoff clock.
+var timeSleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func drainBody(body io.ReadCloser) error {
 	defer body.Close()
 	_, err := io.Copy(io.Discard, body)
 	return err
 }
 
-var (
-	// Use a single, seeded random source with a lock for concurrent safety.
-	randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
-	randLock   sync.Mutex
-)
-
-func linearJitterBackoff(min, max time.Duration, attemptNum int) time.Duration {
+func linearJitterBackoff(min, max time.Duration, attemptNum int, jitterFn func() float64) time.Duration {
 	// attemptNum always starts at zero but we want to start at 1 for multiplication
 	attemptNum++
 
@@ -242,13 +705,85 @@ func linearJitterBackoff(min, max time.Duration, attemptNum int) time.Duration {
 	// multiply by the attemptNum. attemptNum starts at zero so we always
 	// increment here. We first get a random percentage, then apply that to the
 	// difference between min and max, and add to min.
-	randLock.Lock()
-	jitter := randSource.Float64() * float64(max-min)
-	randLock.Unlock()
+	jitter := jitterFn() * float64(max-min)
 	jitterMin := int64(jitter) + int64(min)
 	return time.Duration(jitterMin * int64(attemptNum))
 }
 
+// RequestBodyTransformMiddleware replaces the request body with fn applied to it, e.g. to inject
+// a field or encrypt the payload. The new body is repeatable, so retries resend the transformed
+// content. Requests without a body are passed through untouched.
+func RequestBodyTransformMiddleware(fn func([]byte) ([]byte, error)) Middleware {
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Body == nil || req.Body == http.NoBody {
+				return next(req)
+			}
+			data, err := RepeatableReadRequest(req)
+			if err != nil {
+				return nil, err
+			}
+			if data, err = fn(data); err != nil {
+				return nil, err
+			}
+			setRequestBody(req, data)
+			return next(req)
+		}
+	}
+}
+
+// ResponseBodyTransformMiddleware replaces the response body with fn applied to it, e.g. to
+// decrypt it or strip an anti-XSSI prefix. The new body is repeatable, so GetBody, Unmarshal and
+// RepeatableReadResponse work as usual. The whole body is loaded into memory, for large bodies
+// prefer ResponseStreamTransformMiddleware.
+func ResponseBodyTransformMiddleware(fn func([]byte) ([]byte, error)) Middleware {
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			res, err := next(req)
+			if err != nil || res == nil || res.Body == nil {
+				return res, err
+			}
+			data, err := RepeatableReadResponse(res)
+			if err != nil {
+				return nil, err
+			}
+			if data, err = fn(data); err != nil {
+				return nil, err
+			}
+			res.Body = newRepeatableReader(data)
+			res.ContentLength = int64(len(data))
+			if res.Header != nil {
+				res.Header.Set("Content-Length", strconv.Itoa(len(data)))
+			}
+			return res, nil
+		}
+	}
+}
+
+// ResponseStreamTransformMiddleware wraps the response body with the reader returned by fn, so
+// the transformation happens while the body is consumed and nothing is buffered up front.
+// Closing the body still closes the original one. Since the final size is unknown,
+// ContentLength is reset to -1.
+func ResponseStreamTransformMiddleware(fn func(io.Reader) io.Reader) Middleware {
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			res, err := next(req)
+			if err != nil || res == nil || res.Body == nil {
+				return res, err
+			}
+			res.Body = readCloser{Reader: fn(res.Body), Closer: res.Body}
+			res.ContentLength = -1
+			res.Header.Del("Content-Length")
+			return res, nil
+		}
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
 func MiddlewareSetAllowedStatusCode(codes ...int) Middleware {
 	codeMap := make(map[int]bool)
 	for _, code := range codes {
@@ -278,6 +813,23 @@ func MiddlewareSetBlockedStatusCode(codes ...int) Middleware {
 }
 
 func MiddlewareCheckStatusCode(fn func(int) bool) Middleware {
+	return checkStatusCode(fn, -1)
+}
+
+// MiddlewareStatusCodeWithLimit is MiddlewareSetAllowedStatusCode with control over the body
+// quoted in the error message: at most limit bytes of it, none if limit is 0, all of it if limit
+// is negative. The whole body stays available in StatusError.Body either way.
+func MiddlewareStatusCodeWithLimit(limit int, codes ...int) Middleware {
+	codeMap := make(map[int]bool)
+	for _, code := range codes {
+		codeMap[code] = true
+	}
+	return checkStatusCode(func(c int) bool {
+		return len(codes) == 0 || codeMap[c]
+	}, limit)
+}
+
+func checkStatusCode(fn func(int) bool, limit int) Middleware {
 	return func(next Endpoint) Endpoint {
 		return func(req *http.Request) (*http.Response, error) {
 			resp, err := next(req)
@@ -286,7 +838,14 @@ func MiddlewareCheckStatusCode(fn func(int) bool) Middleware {
 			}
 			if !fn(resp.StatusCode) {
 				data, _ := RepeatableReadResponse(resp)
-				return nil, fmt.Errorf("%s %s %s %s", req.Method, req.URL.String(), resp.Status, data)
+				statusErr := newStatusError(req, resp, data)
+				switch {
+				case limit == 0:
+					statusErr.bodyLimit = statusBodyHidden
+				case limit > 0:
+					statusErr.bodyLimit = limit
+				}
+				return nil, statusErr
 			}
 			return resp, err
 		}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+)
+
+// mirrorTimeout bounds each shadow request, so that a slow shadow target cannot pile up goroutines.
+const mirrorTimeout = defaultConnectTimeout
+
+// mirrorClient sends the shadow requests. It has its own transport: shadow traffic must not
+// compete with the primary requests for pooled connections.
+var mirrorClient = &http.Client{Transport: DefaultPooledTransport(), Timeout: mirrorTimeout}
+
+// MirrorMiddleware copies a sampled fraction of the requests, from 0 (none) to 1 (all), and sends
+// the copy asynchronously to target, e.g. "http://canary.internal:8080", keeping the path and
+// query of the original request. The shadow response is drained and discarded and its errors
+// only go to the package logger (see SetPackageLogger), so the primary request and its result
+// are not affected, apart from its body being buffered in memory (see Request.Clone). An
+// invalid target disables mirroring.
+func MirrorMiddleware(target string, sampleRate float64) Middleware {
+	targetURL, err := url.Parse(target)
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			if err != nil || !sampled(sampleRate) {
+				return next(req)
+			}
+			shadow, cloneErr := FromRequest(req).Clone()
+			if cloneErr != nil {
+				return next(req)
+			}
+			shadow = shadow.WithContext(context.WithoutCancel(req.Context()))
+			shadow.URL.Scheme = targetURL.Scheme
+			shadow.URL.Host = targetURL.Host
+			shadow.Host = ""
+			shadow.RequestURI = ""
+			go func() {
+				res, err := mirrorClient.Do(shadow)
+				if err != nil {
+					logf("http: mirror %s %s: %v", shadow.Method, shadow.URL, err)
+					return
+				}
+				drainBody(res.Body)
+			}()
+			return next(req)
+		}
+	}
+}
+
+func sampled(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate
+}
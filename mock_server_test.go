@@ -2,11 +2,13 @@ package http
 
 import (
 	"bufio"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -16,6 +18,8 @@ type MockServer struct {
 	mux       *http.ServeMux
 	server    *ServerOnAnyPort
 	URLPrefix string
+	// RootCAs trusts the self-signed certificate of a server started with ServeTLSBackground.
+	RootCAs *x509.CertPool
 }
 
 func NewMockServer() *MockServer {
@@ -38,6 +42,17 @@ func (ms *MockServer) ServeBackground() func() {
 	}
 }
 
+// ServeTLSBackground is the https counterpart of ServeBackground: the server presents a
+// self-signed certificate for 127.0.0.1, trusted by RootCAs.
+func (ms *MockServer) ServeTLSBackground() func() {
+	server := httptest.NewUnstartedServer(ms.mux)
+	server.StartTLS()
+	ms.RootCAs = x509.NewCertPool()
+	ms.RootCAs.AddCert(server.Certificate())
+	ms.URLPrefix = server.URL
+	return server.Close
+}
+
 func Echo(w http.ResponseWriter, req *http.Request) {
 	args := make(map[string]string)
 	qs := req.URL.Query()
@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"math/rand"
+	randv2 "math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type options struct {
 	Middlewares []Middleware
+	// BypassClient skips the client-level middlewares, see WithBypassClientMiddleware.
+	BypassClient bool
 }
 
 type Option func(*options)
@@ -33,6 +40,16 @@ func WithPrependMiddleware(m Middleware) Option {
 	}
 }
 
+// WithBypassClientMiddleware sends the request without the client-level middlewares (headers,
+// hooks, retry and timeout defaults, ...), e.g. for a health probe that must not carry auth.
+// The request still goes through the client's transport and the other options of the call,
+// so WithTimeout or WithRetry can be used to configure it.
+func WithBypassClientMiddleware() Option {
+	return func(opt *options) {
+		opt.BypassClient = true
+	}
+}
+
 func WithBeforeHook(hook func(*http.Request)) Option {
 	return WithMiddleware(func(next Endpoint) Endpoint {
 		return func(req *http.Request) (*http.Response, error) {
@@ -51,6 +68,19 @@ func WithTimeout(tm time.Duration) Option {
 	})
 }
 
+// WithDeadline bounds the request by an absolute time, e.g. a deadline propagated from upstream.
+// The timeout becomes the time left when the request is sent (or the configured timeout if it
+// is shorter) and, like any timeout, bounds all retries. A deadline already passed fails the
+// request immediately with an error wrapping context.DeadlineExceeded.
+func WithDeadline(t time.Time) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).Deadline = t
+			return next(req)
+		}
+	})
+}
+
 func WithRetry(opt RetryOption) Option {
 	return WithMiddleware(func(next Endpoint) Endpoint {
 		return func(req *http.Request) (*http.Response, error) {
@@ -60,6 +90,33 @@ func WithRetry(opt RetryOption) Option {
 	})
 }
 
+// WithRetryIf retries the request up to max times while predicate, which sees both the response
+// and the error of the last attempt, returns true. It is WithRetry with RetryMax and
+// CheckResponse set; opts may adjust the rest of the RetryOption, e.g. the waits:
+//
+//	client.Get(ctx, uri, WithRetryIf(3, func(res *http.Response, err error) bool {
+//		return err != nil || res.StatusCode == http.StatusTooManyRequests
+//	}, func(opt *RetryOption) { opt.RetryWaitMin = time.Second }))
+func WithRetryIf(max int, predicate func(*http.Response, error) bool, opts ...func(*RetryOption)) Option {
+	opt := RetryOption{RetryMax: max, CheckResponse: predicate}
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	return WithRetry(opt)
+}
+
+// WithCaptureRequestBody keeps the request body readable after it was sent, so that after hooks
+// and response interceptors can log what was sent with RepeatableReadRequest(res.Request). The body
+// is buffered in memory. The debug logger (SetDebug) and the audit middleware always capture it.
+func WithCaptureRequestBody() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).CaptureBody = true
+			return next(req)
+		}
+	})
+}
+
 func WithAfterHook(hook func(*http.Response)) Option {
 	return WithMiddleware(func(next Endpoint) Endpoint {
 		return func(req *http.Request) (*http.Response, error) {
@@ -93,24 +150,279 @@ func WithoutQuery(k string) Option {
 	})
 }
 
+// WithHost sets the Host presented to the server (req.Host) independently of the URL, e.g. to
+// reach a virtual host through an IP address. The connection still goes to the URL's host.
+func WithHost(host string) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Host = host
+			return next(req)
+		}
+	})
+}
+
+// WithConnectionClose closes the connection once this request is done instead of returning it
+// to the pool, e.g. for a long poll that should not hold a pooled connection. Unlike
+// DisableKeepAlive it affects this request only and leaves the shared transport untouched.
+func WithConnectionClose() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Close = true
+			req.Header.Set("Connection", "close")
+			return next(req)
+		}
+	})
+}
+
+// WithoutHeader removes the header name from this request, including a default set on the
+// client with SetHeader: option middlewares run after the client ones, so the removal wins.
+func WithoutHeader(name string) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Del(name)
+			if strings.EqualFold(name, "host") {
+				req.Host = ""
+			}
+			return next(req)
+		}
+	})
+}
+
+// WithMinTLSVersion sets the minimum TLS version (e.g. tls.VersionTLS12) accepted for this request.
+//
+// TLS settings belong to the transport, so the request is sent through a clone of the client's
+// transport carrying the given minimum version. The clone is created on first use and cached per
+// version, which means:
+//   - requests with different minimum versions never share connections, each version has its own pool;
+//   - the clone snapshots the transport settings at creation time, later calls such as
+//     SetMaxIdleConns or DisableKeepAlive do not affect it.
+func WithMinTLSVersion(version uint16) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).MinTLSVersion = version
+			return next(req)
+		}
+	})
+}
+
+// WithProfile sends the request through the transport registered under name with
+// RegisterProfile, e.g. one with a client certificate or a proxy. The client's middlewares and
+// options apply as usual. An unknown profile falls back to the client's transport.
+func WithProfile(name string) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).Profile = name
+			return next(req)
+		}
+	})
+}
+
+// WithClientTrace attaches trace to the request context to observe low-level events such as
+// DNS lookups, connection reuse and TLS handshakes. A trace already present in the context is
+// kept: both traces receive the events.
+func WithClientTrace(trace *httptrace.ClientTrace) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			// httptrace composes hooks by mutating the trace it is given, copy it so the
+			// same trace can be reused across requests.
+			t := *trace
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &t))
+			return next(req)
+		}
+	})
+}
+
+// withHTTPVersion sends the request through a transport for the given HTTP major version.
+func withHTTPVersion(major int) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).HTTPVersion = major
+			return next(req)
+		}
+	})
+}
+
+// WithForceHTTP1 sends the request over HTTP/1.1 even if the server supports HTTP/2, e.g. to
+// work around a buggy HTTP/2 endpoint. Like WithMinTLSVersion, it goes through a clone of the
+// client's transport with its own connection pool.
+func WithForceHTTP1() Option {
+	return withHTTPVersion(1)
+}
+
+// WithForceHTTP2 attempts HTTP/2 for the request, which a client with a custom dialer does not
+// do by default. Servers without HTTP/2 support are still reached over HTTP/1.1. Like
+// WithMinTLSVersion, it goes through a clone of the client's transport with its own connection pool.
+func WithForceHTTP2() Option {
+	return withHTTPVersion(2)
+}
+
+// WithErrorDecoder fails requests answered with a non-2xx status with a *StatusError whose
+// Decoded field holds the JSON body decoded into a fresh value from into. When that value
+// implements error, errors.As reaches it through the *StatusError:
+//
+//	err := client.Get(ctx, uri, WithErrorDecoder(func() any { return new(APIError) })).Error()
+//	var apiErr *APIError
+//	if errors.As(err, &apiErr) { ... }
+func WithErrorDecoder(into func() any) Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			res, err := next(req)
+			if err != nil || res == nil || (res.StatusCode >= 200 && res.StatusCode < 300) {
+				return res, err
+			}
+			data, _ := RepeatableReadResponse(res)
+			statusErr := newStatusError(req, res, data)
+			if v := into(); json.Unmarshal(data, v) == nil {
+				statusErr.Decoded = v
+			}
+			return nil, statusErr
+		}
+	})
+}
+
+// WithProblemDetails makes the status code middlewares (MiddlewareSetAllowedStatusCode, ...)
+// decode an RFC 7807 application/problem+json body into the Decoded field of their *StatusError,
+// so that errors.As reaches the *ProblemDetails. Without it the body is kept as is.
+func WithProblemDetails() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).ParseProblem = true
+			return next(req)
+		}
+	})
+}
+
+// WithRequireBody makes Response.Unmarshal fail with ErrEmptyBody when the body is empty, even
+// if no target is given, so that a missing payload is reported as such rather than as a JSON
+// syntax error. 204 and 304 responses are exempt.
+func WithRequireBody() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).RequireBody = true
+			return next(req)
+		}
+	})
+}
+
+// WithKeepPartialDownload keeps the path+".part" file of a DownloadToFile or SaveToFile that
+// failed midway instead of removing it, e.g. to resume it later with a Range request.
+func WithKeepPartialDownload() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			getValue(req).KeepPartial = true
+			return next(req)
+		}
+	})
+}
+
 func WithHeader(k, v string) Option {
 	return WithHeaders(map[string]string{k: v})
 }
 
+// WithAccept sets the Accept header, e.g. "application/json", to ask a server that can answer
+// in several formats for a given one. It overrides a client default set with SetAccept.
+func WithAccept(mediaType string) Option {
+	return WithHeader("Accept", mediaType)
+}
+
 type RetryHook func(*http.Request, int)
 
+// RetryOption configures retries. When retries are enabled the request body, whatever its type
+// (streaming readers included), is buffered in memory before the first attempt so that every
+// attempt sends it in full; very large uploads should not be retried this way.
 type RetryOption struct {
-	RetryMax      int
-	RetryWaitMin  time.Duration                                  // optional
-	RetryWaitMax  time.Duration                                  // optional
+	RetryMax     int
+	RetryWaitMin time.Duration // optional
+	RetryWaitMax time.Duration // optional
+	// CheckResponse decides whether to retry, by default any error is retried. When set, its
+	// answer is final, connection errors included: a request the server may already have
+	// processed, e.g. a POST hit by a connection reset, is only replayed if it says so. Call
+	// IsRetryableError from it to retry the transient connection failures.
 	CheckResponse func(*http.Response, error) (shouldRetry bool) // optional
+	// CheckResponseWithAttempt is CheckResponse with the index of the attempt being checked, 0 for
+	// the first one, e.g. to retry a 429 up to 5 times but a 500 only twice. It takes precedence
+	// over CheckResponse when both are set, and its answer is final in the same way.
+	CheckResponseWithAttempt func(res *http.Response, err error, attempt int) (shouldRetry bool) // optional
+	// PeekBytes, when positive, limits what CheckResponse sees of the response body to its first
+	// PeekBytes bytes, readable through RepeatableReadResponse, so that deciding on a retry does
+	// not buffer a large successful response. The full body is left intact for the caller.
+	PeekBytes int // optional
+	// RetryStatuses lists response status codes that trigger a retry, e.g. 429, 502, 503, 504.
+	// It is combined with CheckResponse: a retry happens if either of them asks for it.
+	RetryStatuses []int // optional
+	// BackoffByStatus overrides the wait before the next attempt when the response that triggered
+	// the retry has one of these statuses, e.g. a long wait for 429 and a short one for 503. A
+	// Retry-After header on such a response still wins. Other statuses and errors use the
+	// default backoff.
+	BackoffByStatus map[int]time.Duration // optional
+	// PerAttemptTimeout bounds each attempt, so that a hung attempt is abandoned and retried.
+	// When retries are enabled, the request timeout (SetTimeout/WithTimeout) bounds the whole
+	// sequence of attempts, including the waits between them; each attempt then gets the
+	// smaller of PerAttemptTimeout and the time left. No new attempt is started if the
+	// remaining time would already be spent waiting for it.
+	PerAttemptTimeout time.Duration // optional
+	// ModifyRequest runs before each attempt, attempt being 0 for the first one, and may change
+	// anything in the request: URL, headers or body. A replaced body is buffered and its
+	// ContentLength fixed up. Returning an error stops the retries with that error.
+	ModifyRequest func(req *http.Request, attempt int) error // optional
+	// Rand is the source of the jitter added to the waits between attempts, e.g. a seeded one
+	// for reproducible load tests, see NewRetryRand. By default a per-goroutine source is used.
+	Rand *RetryRand // optional
+	// NoBodyBuffer keeps request bodies that cannot be rewound, e.g. a pipe or a plain io.Reader
+	// passed to Post, from being buffered in memory so that they can be sent again: such a
+	// request is sent once, without retry, whatever CheckResponse says. Use it for large
+	// streaming uploads, where buffering could exhaust memory. Rewindable bodies (WithBodyFile,
+	// or a body already buffered for a mock or WithCaptureRequestBody) are retried as usual.
+	NoBodyBuffer bool // optional
+}
+
+// RetryRand is a seeded random source for RetryOption.Rand. Unlike *rand.Rand, it is safe for
+// concurrent use, so that one source can be shared by concurrent requests.
+type RetryRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRetryRand returns a RetryRand seeded with seed.
+func NewRetryRand(seed int64) *RetryRand {
+	return &RetryRand{r: rand.New(rand.NewSource(seed))}
 }
 
+// Float64 returns a random fraction in [0, 1).
+func (r *RetryRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// jitter returns a random fraction in [0, 1) for the retry backoff.
+func (opt *RetryOption) jitter() float64 {
+	if opt.Rand == nil {
+		return randv2.Float64()
+	}
+	return opt.Rand.Float64()
+}
+
+// setRequestHeader sets the headers on req. The transport ignores the header map for Host,
+// Content-Length and Transfer-Encoding and uses the request fields instead, so those are
+// mapped onto the fields as well.
 func setRequestHeader(req *http.Request, header map[string]string) {
 	for k, v := range header {
 		req.Header.Set(k, v)
-		if strings.ToLower(k) == "host" {
+		switch http.CanonicalHeaderKey(k) {
+		case "Host":
 			req.Host = v
+		case "Content-Length":
+			if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n >= 0 {
+				req.ContentLength = n
+			}
+		case "Transfer-Encoding":
+			if strings.EqualFold(strings.TrimSpace(v), "chunked") {
+				req.TransferEncoding = []string{"chunked"}
+				req.ContentLength = -1
+			} else if strings.EqualFold(strings.TrimSpace(v), "identity") {
+				req.TransferEncoding = nil
+			}
 		}
 	}
 }
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// PreparedRequest is a request shape parsed once by Client.Prepare and executed many times
+// with different path parameters. It is safe for concurrent use.
+type PreparedRequest struct {
+	client *clientImpl
+	method string
+	// segments alternates literal text and parameter names: even indexes are literals,
+	// odd indexes are the names found between braces.
+	segments []string
+	size     int
+	options  *options
+}
+
+// Prepare parses urlTemplate, e.g. "https://api.example.com/users/{id}/posts", and resolves
+// opts once, so that repeated calls only substitute the parameters and send the request.
+func (client *clientImpl) Prepare(method, urlTemplate string, opts ...Option) *PreparedRequest {
+	p := &PreparedRequest{
+		client:  client,
+		method:  method,
+		options: client.resolveOptions(opts...),
+	}
+	rest := urlTemplate
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		p.segments = append(p.segments, rest[:start], rest[start+1:end])
+		p.size += start
+		rest = rest[end+1:]
+	}
+	p.segments = append(p.segments, rest)
+	p.size += len(rest)
+	return p
+}
+
+// Do substitutes each {param} placeholder with the path-escaped value from params and
+// executes the request. Extra options are applied after the prepared ones. A placeholder
+// without a value fails the request.
+func (p *PreparedRequest) Do(ctx context.Context, params map[string]string, body io.Reader, opts ...Option) *Response {
+	uri, err := p.url(params)
+	if err != nil {
+		return buildResponse(ctx, nil, err)
+	}
+	opt := p.options
+	if len(opts) > 0 {
+		extra := p.client.resolveOptions(opts...)
+		opt = &options{
+			Middlewares:  append(opt.Middlewares[:len(opt.Middlewares):len(opt.Middlewares)], extra.Middlewares...),
+			BypassClient: opt.BypassClient || extra.BypassClient,
+		}
+	}
+	return p.client.doURL(ctx, p.method, uri, body, opt)
+}
+
+func (p *PreparedRequest) url(params map[string]string) (string, error) {
+	if len(p.segments) == 1 {
+		return p.segments[0], nil
+	}
+	var sb strings.Builder
+	sb.Grow(p.size + 16*len(p.segments)/2)
+	for i, seg := range p.segments {
+		if i%2 == 0 {
+			sb.WriteString(seg)
+			continue
+		}
+		val, ok := params[seg]
+		if !ok {
+			return "", fmt.Errorf("missing value for url parameter %q", seg)
+		}
+		sb.WriteString(url.PathEscape(val))
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+const mimeProblemJSON = "application/problem+json"
+
+// ProblemDetails is an RFC 7807 problem, sent with the application/problem+json content type.
+// Members other than the standard ones are kept in Extensions.
+type ProblemDetails struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func (p *ProblemDetails) Error() string {
+	msg := p.Title
+	if msg == "" {
+		msg = http.StatusText(p.Status)
+	}
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	if p.Type != "" && p.Type != "about:blank" {
+		msg += " (" + p.Type + ")"
+	}
+	return msg
+}
+
+func (p *ProblemDetails) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	standard := map[string]any{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+	}
+	for name, raw := range members {
+		if field, ok := standard[name]; ok {
+			if err := json.Unmarshal(raw, field); err != nil {
+				return fmt.Errorf("problem member %q: %w", name, err)
+			}
+			continue
+		}
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]any)
+		}
+		p.Extensions[name] = v
+	}
+	return nil
+}
+
+func (p ProblemDetails) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	for name, v := range map[string]string{"type": p.Type, "title": p.Title, "detail": p.Detail, "instance": p.Instance} {
+		if v != "" {
+			members[name] = v
+		}
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	return json.Marshal(members)
+}
+
+func isProblem(res *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return mediaType == mimeProblemJSON
+}
+
+// parseProblem decodes body if res announces a problem, it returns nil otherwise.
+func parseProblem(res *http.Response, body []byte) *ProblemDetails {
+	if !isProblem(res) {
+		return nil
+	}
+	p := new(ProblemDetails)
+	if json.Unmarshal(body, p) != nil {
+		return nil
+	}
+	return p
+}
+
+// UnmarshalProblem returns the RFC 7807 problem carried by the response, detected by its
+// application/problem+json content type. A problem found in a response rejected by a status
+// code middleware is returned as well, with WithProblemDetails. It returns nil and the request
+// error, if any, when the response is not a problem.
+//
+// NOTE: This method consumes the response body and can only be called once.
+func (r *Response) UnmarshalProblem() (*ProblemDetails, error) {
+	var problem *ProblemDetails
+	err := r.HandleResult(func(res *http.Response) error {
+		if res.Body == nil || !isProblem(res) {
+			return nil
+		}
+		problem = new(ProblemDetails)
+		if err := json.NewDecoder(res.Body).Decode(problem); err != nil {
+			problem = nil
+			return fmt.Errorf("decode problem details: %w", err)
+		}
+		return nil
+	})
+	if problem == nil && err != nil {
+		if errors.As(err, &problem) {
+			return problem, nil
+		}
+	}
+	return problem, err
+}
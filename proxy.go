@@ -0,0 +1,90 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// noProxyFilter holds the list of SetNoProxyCIDRs. It is installed once in front of the
+// transport proxy, later calls only replace the list. It is shared with forked clients, like
+// the transport.
+type noProxyFilter struct {
+	once sync.Once
+	list atomic.Pointer[noProxyList]
+}
+
+type noProxyList struct {
+	nets []*net.IPNet
+	// err is the parse error of the list, it fails every request rather than silently sending
+	// through the proxy the traffic meant to bypass it.
+	err error
+}
+
+// SetNoProxyCIDRs makes requests bypass the proxy when the destination address falls in one of
+// cidrs, e.g. "10.0.0.0/8". Each call replaces the list of the previous one, an empty list
+// restores the proxy for all destinations. Host names are resolved first, honoring
+// WithResolvedHost, and the proxy is bypassed if any of their addresses matches; the addresses
+// are reused for 30 seconds, and IP literals are matched without lookup. Other destinations keep the proxy chosen so far, by default from
+// the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+//
+// An invalid CIDR is reported to the package logger right away (see SetPackageLogger) and fails
+// every request with a descriptive error until a valid list is set. Like other transport
+// settings, it does not affect transports already derived for per-request settings (see
+// WithMinTLSVersion).
+func (client *clientImpl) SetNoProxyCIDRs(cidrs []string) Client {
+	list := &noProxyList{nets: make([]*net.IPNet, 0, len(cidrs))}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			list.err = fmt.Errorf("invalid no-proxy cidr %q: %w", cidr, err)
+			logf("http: %v", list.err)
+			break
+		}
+		list.nets = append(list.nets, ipnet)
+	}
+	filter := client.noProxy
+	filter.list.Store(list)
+	filter.once.Do(func() {
+		proxy, dialer := client.transport.Proxy, client.dialer
+		client.transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return filter.proxy(req, proxy, dialer)
+		}
+	})
+	return client
+}
+
+// proxy returns the proxy for req: none if its destination is in the list, or else the one
+// chosen by next.
+func (filter *noProxyFilter) proxy(req *http.Request, next func(*http.Request) (*url.URL, error), dialer *dialer) (*url.URL, error) {
+	list := filter.list.Load()
+	if list.err != nil {
+		return nil, list.err
+	}
+	if next == nil {
+		return nil, nil
+	}
+	if len(list.nets) == 0 {
+		return next(req)
+	}
+	host := req.URL.Hostname()
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = dialer.lookup(req.Context(), host); err != nil {
+			// Leave the failure to the proxy or the dialer, which report it in context.
+			return next(req)
+		}
+	}
+	for _, ip := range ips {
+		for _, ipnet := range list.nets {
+			if ipnet.Contains(ip) {
+				return nil, nil
+			}
+		}
+	}
+	return next(req)
+}
@@ -0,0 +1,321 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+)
+
+// RecorderBodyLimit caps the number of body bytes written by the recorder, for both request and
+// response. Longer bodies are cut and the recorded message carries a RecorderTruncatedHeader.
+var RecorderBodyLimit = 1 << 20
+
+// RecorderRedactedHeaders lists the headers whose values the recorder replaces with
+// RecorderRedactedValue, on both requests and responses.
+var RecorderRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+const (
+	// RecorderRedactedValue replaces the values of the RecorderRedactedHeaders.
+	RecorderRedactedValue = "REDACTED"
+	// RecorderTruncatedHeader marks a recorded message whose body was cut to RecorderBodyLimit.
+	RecorderTruncatedHeader = "X-Recorder-Truncated"
+)
+
+const recorderMiddlewareName = "recorder"
+
+// ErrNotRecorded is returned (wrapped) by the endpoint of LoadRecording for a request that was
+// not recorded.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// RecorderMiddleware writes each request and its response to w, in the format of
+// httputil.DumpRequest and httputil.DumpResponse with their bodies, so that LoadRecording can
+// replay them. The bodies are passed through unchanged while at most RecorderBodyLimit bytes of
+// each are kept for the record, so recording neither buffers large bodies nor delays streamed
+// ones. An exchange is written once its response body has been read to the end or closed; a body
+// closed early is recorded as far as it was read and marked truncated, like a body cut to
+// RecorderBodyLimit. The values of the RecorderRedactedHeaders are masked. Requests that fail
+// without a response are not recorded. Writes to w are serialized, one exchange at a time.
+func RecorderMiddleware(w io.Writer) Middleware {
+	var mu sync.Mutex
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			reqBody := teeRequestBody(req)
+			res, err := next(req)
+			if err != nil || res == nil {
+				return res, err
+			}
+			record := func(resBody *recordTee) {
+				var buf bytes.Buffer
+				if err := dumpRecordedRequest(&buf, req, reqBody); err != nil {
+					logf("http: recorder %s %s: %v", req.Method, req.URL, err)
+					return
+				}
+				if err := dumpRecordedResponse(&buf, res, resBody); err != nil {
+					logf("http: recorder %s %s: %v", req.Method, req.URL, err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if _, err := w.Write(buf.Bytes()); err != nil {
+					logf("http: recorder %s %s: %v", req.Method, req.URL, err)
+				}
+			}
+			if res.Body == nil || res.Body == http.NoBody {
+				record(new(recordTee))
+				return res, nil
+			}
+			if rr, ok := res.Body.(*repeatableReader); ok {
+				/* already in memory, e.g. from a mock */
+				record(recordTeeOf(rr.data))
+				return res, nil
+			}
+			res.Body = &recordingBody{ReadCloser: res.Body, tee: recordTee{r: res.Body}, size: res.ContentLength, record: record}
+			return res, nil
+		}
+	}
+}
+
+// recordTee keeps the first RecorderBodyLimit bytes read through it. It is locked because the
+// transport may still be sending the request body when the response arrives.
+type recordTee struct {
+	r    io.Reader
+	mu   sync.Mutex
+	data []byte
+	// n counts all the bytes read, kept or not.
+	n int64
+	// partial is set when the body was not read to the end.
+	partial bool
+}
+
+func recordTeeOf(data []byte) *recordTee {
+	t := &recordTee{n: int64(len(data))}
+	t.keep(data)
+	return t
+}
+
+func (t *recordTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keep(p[:n])
+	t.n += int64(n)
+	return n, err
+}
+
+func (t *recordTee) keep(p []byte) {
+	if room := RecorderBodyLimit - len(t.data); room > 0 {
+		t.data = append(t.data, p[:min(len(p), room)]...)
+	}
+}
+
+// body returns the recorded body and whether it misses part of the body.
+func (t *recordTee) body() ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return bytes.Clone(t.data), t.partial || t.n > int64(len(t.data))
+}
+
+func (t *recordTee) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.data, t.n = t.data[:0], 0
+}
+
+// teeRequestBody makes the request body keep what is sent of it for the record. A rewindable
+// body stays rewindable and starts over its record when rewound, e.g. for a retry.
+func teeRequestBody(req *http.Request) *recordTee {
+	if req.Body == nil || req.Body == http.NoBody {
+		return new(recordTee)
+	}
+	tee := &recordTee{r: req.Body}
+	if rw, ok := req.Body.(rewinder); ok {
+		req.Body = &rewindableRecordBody{ReadCloser: req.Body, tee: tee, rw: rw}
+	} else {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{tee, req.Body}
+	}
+	return tee
+}
+
+type rewindableRecordBody struct {
+	io.ReadCloser
+	tee *recordTee
+	rw  rewinder
+}
+
+func (b *rewindableRecordBody) Read(p []byte) (int, error) {
+	return b.tee.Read(p)
+}
+
+func (b *rewindableRecordBody) SeekStart() error {
+	b.tee.reset()
+	return b.rw.SeekStart()
+}
+
+// recordingBody records the exchange once the response body is read to the end or closed.
+type recordingBody struct {
+	io.ReadCloser
+	tee recordTee
+	// size is the announced length of the body, -1 if unknown.
+	size   int64
+	once   sync.Once
+	record func(*recordTee)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.tee.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() { b.record(&b.tee) })
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.tee.partial = b.size < 0 || b.tee.n < b.size
+		b.record(&b.tee)
+	})
+	return err
+}
+
+func dumpRecordedRequest(w io.Writer, req *http.Request, tee *recordTee) error {
+	clone := req.Clone(req.Context())
+	clone.Header = redactHeader(req.Header)
+	clone.TransferEncoding = nil
+	body, truncated := tee.body()
+	if truncated {
+		clone.Header.Set(RecorderTruncatedHeader, "true")
+	}
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+	// DumpRequest writes the Content-Length header only if it is part of the header, without
+	// it the request could not be read back.
+	clone.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	data, err := httputil.DumpRequest(clone, true)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func dumpRecordedResponse(w io.Writer, res *http.Response, tee *recordTee) error {
+	clone := *res
+	clone.Header = redactHeader(res.Header)
+	clone.TransferEncoding = nil
+	clone.Trailer = nil
+	body, truncated := tee.body()
+	if truncated {
+		clone.Header.Set(RecorderTruncatedHeader, "true")
+	}
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+	data, err := httputil.DumpResponse(&clone, true)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	if out == nil {
+		out = make(http.Header)
+	}
+	for _, name := range RecorderRedactedHeaders {
+		if values := out.Values(name); len(values) > 0 {
+			redacted := make([]string, len(values))
+			for i := range redacted {
+				redacted[i] = RecorderRedactedValue
+			}
+			out[http.CanonicalHeaderKey(name)] = redacted
+		}
+	}
+	return out
+}
+
+// recordedExchange is a request read back by LoadRecording, with its response.
+type recordedExchange struct {
+	key    string
+	res    *http.Response
+	body   []byte
+	served bool
+}
+
+// LoadRecording reads the exchanges written by a recorder (see Client.EnableRecorder) and
+// returns an endpoint replaying them, to pass to SetMock. A request gets the response recorded
+// for the same method, host and request URI: repeated requests get the recorded responses in
+// order, then the last one again. Other requests fail with ErrNotRecorded. Request bodies are
+// not compared.
+func LoadRecording(r io.Reader) (Endpoint, error) {
+	br := bufio.NewReader(r)
+	var exchanges []*recordedExchange
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return nil, fmt.Errorf("read recorded request %d: %w", len(exchanges)+1, err)
+		}
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			return nil, fmt.Errorf("read recorded request %d: %w", len(exchanges)+1, err)
+		}
+		res, err := http.ReadResponse(br, req)
+		if err != nil {
+			return nil, fmt.Errorf("read recorded response %d: %w", len(exchanges)+1, err)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read recorded response %d: %w", len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, &recordedExchange{key: recordingKey(req), res: res, body: body})
+	}
+	var mu sync.Mutex
+	return func(req *http.Request) (*http.Response, error) {
+		key := recordingKey(req)
+		mu.Lock()
+		defer mu.Unlock()
+		var last *recordedExchange
+		for _, ex := range exchanges {
+			if ex.key != key {
+				continue
+			}
+			if !ex.served {
+				ex.served = true
+				return ex.response(req), nil
+			}
+			last = ex
+		}
+		if last == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotRecorded, key)
+		}
+		return last.response(req), nil
+	}, nil
+}
+
+func (ex *recordedExchange) response(req *http.Request) *http.Response {
+	res := *ex.res
+	res.Header = ex.res.Header.Clone()
+	res.Body = io.NopCloser(bytes.NewReader(ex.body))
+	res.Request = req
+	return &res
+}
+
+func recordingKey(req *http.Request) string {
+	host := req.Host
+	if host == "" && req.URL != nil {
+		host = req.URL.Host
+	}
+	return req.Method + " " + host + req.URL.RequestURI()
+}
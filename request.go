@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"net/http"
+)
+
+// Request wraps an *http.Request with helpers for middlewares, see FromRequest.
+type Request struct {
+	*http.Request
+}
+
+// FromRequest wraps req. The wrapper works on req itself, nothing is copied.
+func FromRequest(req *http.Request) *Request {
+	return &Request{Request: req}
+}
+
+// SetSpan tags the request with a trace or span identifier, which the debug logger
+// (TransportInfo.Span) and the audit records (AuditRecord.Span) report. It is stored in the
+// per-request value, so it is kept across retries. On a request that has not entered a client
+// yet the value is attached to the wrapped request, use r.Request afterwards.
+func (r *Request) SetSpan(id string) {
+	gv := getValue(r.Request)
+	if gv == nil {
+		gv = getOrCreateValue(r.Request)
+		r.Request = setValue(r.Request, gv)
+	}
+	gv.Span = id
+}
+
+// Span returns the identifier set by SetSpan, or an empty string.
+func (r *Request) Span() string {
+	if gv := getValue(r.Request); gv != nil {
+		return gv.Span
+	}
+	return ""
+}
+
+// RetryOption returns the retry settings in effect for the request: a request-level WithRetry
+// overrides the client's SetRetry. Seen from a middleware, it reflects the middlewares run so
+// far, the final value is the one seen by before hooks and the mock. It is nil when retries are
+// not configured or disabled (RetryMax 0). The result is a copy, changing it has no effect.
+func (r *Request) RetryOption() *RetryOption {
+	gv := getValue(r.Request)
+	if gv == nil || gv.RetryOption == nil || gv.RetryOption.RetryMax <= 0 {
+		return nil
+	}
+	opt := *gv.RetryOption
+	return &opt
+}
+
+// Cancel aborts the request from within a middleware, e.g. when a pre-flight check fails: the
+// request context is canceled with err as its cause (see context.Cause), which stops any
+// in-flight attempt, retry wait or body read, and the request fails with an error wrapping err.
+// A nil err cancels with context.Canceled. It does nothing on a request that is not being sent
+// by a client, or once the request is done.
+func (r *Request) Cancel(err error) {
+	if gv := getValue(r.Request); gv != nil && gv.cancel != nil {
+		if err == nil {
+			err = context.Canceled
+		}
+		gv.cancel(err)
+	}
+}
+
+// Clone returns a deep copy of the request, including its body, which http.Request.Clone does
+// not copy. The body is read once into memory (see RepeatableReadRequest) and both the original
+// request and the clone are given their own repeatable reader over it, so the clone can be sent
+// elsewhere, e.g. to mirror traffic, without disturbing the original request.
+func (r *Request) Clone() (*http.Request, error) {
+	clone := r.Request.Clone(r.Context())
+	if r.Body == nil || r.Body == http.NoBody {
+		return clone, nil
+	}
+	data, err := RepeatableReadRequest(r.Request)
+	if err != nil {
+		return nil, err
+	}
+	setRequestBody(r.Request, data)
+	setRequestBody(clone, data)
+	return clone, nil
+}
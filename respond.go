@@ -0,0 +1,108 @@
+package http
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	mimeJSON    = "application/json"
+	mimeXML     = "application/xml"
+	mimeTextXML = "text/xml"
+)
+
+type respondConfig struct {
+	status int
+}
+
+// RespondOption customizes Respond.
+type RespondOption func(*respondConfig)
+
+// RespondStatus sets the response status code, 200 by default.
+func RespondStatus(code int) RespondOption {
+	return func(c *respondConfig) { c.status = code }
+}
+
+// Respond serializes data as JSON or XML depending on the request's Accept header and writes
+// it with the matching Content-Type. JSON is used when the client accepts anything (*/*), sends
+// no Accept header, or accepts neither format.
+func Respond(w http.ResponseWriter, r *http.Request, data any, opts ...RespondOption) error {
+	cfg := &respondConfig{status: http.StatusOK}
+	for _, fn := range opts {
+		fn(cfg)
+	}
+	mediaType := negotiate(r.Header.Get("Accept"))
+	var body []byte
+	var err error
+	if mediaType == mimeJSON {
+		body, err = json.Marshal(data)
+	} else {
+		body, err = xml.Marshal(data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(cfg.status)
+	_, err = w.Write(body)
+	return err
+}
+
+// ErrorBody is the envelope written by RespondError, as {"error":{"code":..,"message":..}}
+// in JSON or <error><code>..</code><message>..</message></error> in XML.
+type ErrorBody struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Code    int      `json:"code" xml:"code"`
+	Message string   `json:"message" xml:"message"`
+}
+
+// RespondError writes err with the given status code in the consistent ErrorBody envelope,
+// negotiated like Respond.
+func RespondError(w http.ResponseWriter, r *http.Request, code int, err error) error {
+	body := ErrorBody{Code: code}
+	if err != nil {
+		body.Message = err.Error()
+	} else {
+		body.Message = http.StatusText(code)
+	}
+	if negotiate(r.Header.Get("Accept")) == mimeJSON {
+		return Respond(w, r, map[string]ErrorBody{"error": body}, RespondStatus(code))
+	}
+	return Respond(w, r, body, RespondStatus(code))
+}
+
+// negotiate picks the supported media type with the highest quality in the Accept header.
+func negotiate(accept string) string {
+	best, bestQ := mimeJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var candidate string
+		switch mediaType {
+		case mimeJSON, "*/*", "application/*":
+			candidate = mimeJSON
+		case mimeXML, mimeTextXML:
+			candidate = mediaType
+		default:
+			continue
+		}
+		// On equal quality JSON wins, it is the default format.
+		if q > bestQ || (q == bestQ && candidate == mimeJSON) {
+			best, bestQ = candidate, q
+		}
+	}
+	return best
+}
@@ -4,20 +4,44 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type Response struct {
 	*http.Response
-	err  error
-	ctx  context.Context
-	read int32
+	err   error
+	ctx   context.Context
+	read  int32
+	value *gValue
+	// deadline is when the request times out, the body must be read by then. Zero means none.
+	deadline time.Time
+	// placeholder is embedded when the request failed without a response, so that reading
+	// fields such as StatusCode stays safe without allocating a separate http.Response.
+	placeholder http.Response
 }
 
+var responsePool = sync.Pool{New: func() any { return new(Response) }}
+
 type ResponseHandler func(*http.Response) error
 
+// ErrNoResponse is the error of a request that ended without a response nor an error, e.g.
+// a mock returning (nil, nil).
+var ErrNoResponse = errors.New("no response")
+
+// ErrBodyConsumed is returned by Body when the response body was already consumed or handed over.
+var ErrBodyConsumed = errors.New("response body already consumed")
+
+// ErrEmptyBody is returned (wrapped) by Unmarshal for an empty body when WithRequireBody is set.
+var ErrEmptyBody = errors.New("empty response body")
+
 // HandleResult is the core method for processing the HTTP response. It ensures that the
 // response body is read and closed only once.
 //
@@ -51,6 +75,10 @@ func (r *Response) Error() error {
 // Unmarshal parses the JSON-encoded response body and stores the result in the
 // value pointed to by obj.
 //
+// The body of 204 No Content and 304 Not Modified responses is never parsed. Other empty
+// bodies fail to parse, or fail with ErrEmptyBody when WithRequireBody is set, which also
+// applies when obj is nil.
+//
 // NOTE: This method consumes the response body and can only be called once.
 func (r *Response) Unmarshal(obj any) error {
 	return r.HandleResult(func(res *http.Response) error {
@@ -61,15 +89,30 @@ func (r *Response) Unmarshal(obj any) error {
 				requrl = r.Response.Request.URL.String()
 			}
 		}
-		if res.Body == nil {
+		if res.Request != nil && res.Request.Method == http.MethodHead {
 			return nil
 		}
-		data, err := io.ReadAll(res.Body)
-		if err != nil {
-			return fmt.Errorf("get response body fail %v, url=%s response_code=%s %w", err, requrl, resCode, err)
+		if res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+			// These responses never carry a body, whatever the server sent is not parsed.
+			return nil
+		}
+		var data []byte
+		if res.Body != nil {
+			var err error
+			if data, err = io.ReadAll(res.Body); err != nil {
+				return fmt.Errorf("get response body fail %v, url=%s response_code=%s %w", err, requrl, resCode, err)
+			}
+		}
+		if len(data) == 0 {
+			if r.value != nil && r.value.RequireBody {
+				return fmt.Errorf("%w, url=%s response_code=%s", ErrEmptyBody, requrl, resCode)
+			}
+			if res.Body == nil {
+				return nil
+			}
 		}
 		if obj != nil {
-			if err = json.Unmarshal(data, obj); err != nil {
+			if err := json.Unmarshal(data, obj); err != nil {
 				return fmt.Errorf("unmarshal body %s fail %v, uri=%s respons_code=%s %w", string(data), err, requrl, resCode, err)
 			}
 		}
@@ -77,6 +120,67 @@ func (r *Response) Unmarshal(obj any) error {
 	})
 }
 
+// DecodeArray streams a top-level JSON array, calling fn once per element without loading the
+// whole array into memory. fn receives a decode function that stores the current element in the
+// value it is given; an element fn does not decode is skipped. An error returned by fn stops the
+// iteration and is returned as is. A null body is an empty array.
+//
+//	var total int
+//	err := res.DecodeArray(func(decode func(any) error) error {
+//		var item Item
+//		if err := decode(&item); err != nil {
+//			return err
+//		}
+//		total += item.Count
+//		return nil
+//	})
+//
+// NOTE: This method consumes the response body and can only be called once.
+func (r *Response) DecodeArray(fn func(decode func(elem any) error) error) error {
+	return r.HandleResult(func(res *http.Response) error {
+		if res.Body == nil {
+			return nil
+		}
+		dec := json.NewDecoder(res.Body)
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("decode json array: %w", err)
+		}
+		if tok == nil {
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("decode json array: unexpected %v, expected [", tok)
+		}
+		for index := 0; dec.More(); index++ {
+			decoded := false
+			decode := func(elem any) error {
+				if decoded {
+					return fmt.Errorf("decode json array: element %d already decoded", index)
+				}
+				decoded = true
+				if err := dec.Decode(elem); err != nil {
+					return fmt.Errorf("decode json array element %d: %w", index, err)
+				}
+				return nil
+			}
+			if err = fn(decode); err != nil {
+				return err
+			}
+			if !decoded {
+				var skip json.RawMessage
+				if err = decode(&skip); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err = dec.Token(); err != nil {
+			return fmt.Errorf("decode json array: %w", err)
+		}
+		return nil
+	})
+}
+
 // GetBody reads and returns the entire response body as a byte slice.
 //
 // NOTE: This method consumes the response body and can only be called once.
@@ -88,6 +192,45 @@ func (r *Response) GetBody() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// Body hands the open response body over to the caller, e.g. to pass it to another subsystem
+// that streams it. The caller owns the body and must close it. The response counts as consumed:
+// later body-processing methods, Error and Release no longer touch the body, and calling Body
+// again returns ErrBodyConsumed. If the request failed, its error is returned instead.
+func (r *Response) Body() (io.ReadCloser, error) {
+	if r.read != 0 {
+		return nil, ErrBodyConsumed
+	}
+	r.read = 1
+	if r.Response == nil || r.Response.Body == nil {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return http.NoBody, nil
+	}
+	if r.err != nil {
+		r.Response.Body.Close()
+		return nil, r.err
+	}
+	if r.ctx == nil && r.deadline.IsZero() {
+		return r.Response.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r.bodyReader(), r.Response.Body}, nil
+}
+
+// bytes reads the body like GetBody and also returns the underlying response, which is nil
+// if the request failed before any response was received. The body is closed either way.
+func (r *Response) bytes() ([]byte, *http.Response, error) {
+	data, err := r.GetBody()
+	res := r.Response
+	if res == &r.placeholder {
+		res = nil
+	}
+	return data, res, err
+}
+
 func (r *Response) MustGetBody() []byte {
 	data, err := r.GetBody()
 	if err != nil {
@@ -97,7 +240,8 @@ func (r *Response) MustGetBody() []byte {
 }
 
 // Save reads the entire response body and writes it to the provided io.Writer.
-// If the writer is nil, the body is read and discarded.
+// If the writer is nil, the body is read and discarded. The copy stops as soon as the request
+// context is done, with the context error, even if the body is still flowing.
 //
 // NOTE: This method consumes the response body and can only be called once.
 func (r *Response) Save(w io.Writer) error {
@@ -106,16 +250,236 @@ func (r *Response) Save(w io.Writer) error {
 			w = io.Discard
 		}
 		if res.Body != nil {
-			_, err := io.Copy(w, r.Response.Body)
+			_, err := io.Copy(w, r.bodyReader())
 			return err
 		}
 		return nil
 	})
 }
 
+// bodyReader returns the response body, bound to the request context and deadline if there
+// are any. The body is read lazily, after send returned its pooled client: the deadline keeps
+// the request timeout in force whatever carried the response.
+func (r *Response) bodyReader() io.Reader {
+	if r.ctx == nil && r.deadline.IsZero() {
+		return r.Response.Body
+	}
+	return &contextReader{ctx: r.ctx, deadline: r.deadline, r: r.Response.Body}
+}
+
+// contextReader fails reads with the context error once ctx is done, or with
+// context.DeadlineExceeded once the deadline is past. Either may be unset. Both are checked
+// between reads only: a read blocked on a stalled body is cut by the timer of the client that
+// sent the request, or by the context if the transport honors it, not by the deadline.
+type contextReader struct {
+	ctx      context.Context
+	deadline time.Time
+	r        io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	if err != nil && err != io.EOF {
+		if ctxErr := cr.err(); ctxErr != nil {
+			return n, ctxErr
+		}
+	}
+	return n, err
+}
+
+func (cr *contextReader) err() error {
+	if cr.ctx != nil {
+		if err := cr.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if !cr.deadline.IsZero() && !time.Now().Before(cr.deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// StatusCode returns the response status code, or 0 if no response was received.
+func (r *Response) StatusCode() int {
+	if r.Response == nil {
+		return 0
+	}
+	return r.Response.StatusCode
+}
+
+// Is2xx reports whether the response has a success status. Like the other status class
+// helpers it does not consume the body and is false if no response was received.
+func (r *Response) Is2xx() bool {
+	return r.StatusCode()/100 == 2
+}
+
+// IsRedirect reports whether the response has a 3xx status, i.e. a redirect that was not followed.
+func (r *Response) IsRedirect() bool {
+	return r.StatusCode()/100 == 3
+}
+
+// Is4xx reports whether the response has a client error status.
+func (r *Response) Is4xx() bool {
+	return r.StatusCode()/100 == 4
+}
+
+// Is5xx reports whether the response has a server error status.
+func (r *Response) Is5xx() bool {
+	return r.StatusCode()/100 == 5
+}
+
+// Header returns the response header, an empty header if no response was received.
+func (r *Response) Header() http.Header {
+	if r.Response == nil || r.Response.Header == nil {
+		return http.Header{}
+	}
+	return r.Response.Header
+}
+
+// FromMock reports whether the response was produced by a mock endpoint (see SetMock)
+// instead of a real round trip.
+func (r *Response) FromMock() bool {
+	return r.value != nil && r.value.FromMock
+}
+
+// Attempts returns how many times the request was sent, including retries: 1 for a request
+// that succeeded on its first try. It is 0 if the request was never sent, e.g. for an invalid URL.
+func (r *Response) Attempts() int {
+	if r.value == nil {
+		return 0
+	}
+	return r.value.Attempts
+}
+
+// ContentLength returns the length of the body announced by the server, or -1 if it is unknown.
+// Unlike the embedded field it is also meaningful for HEAD responses and safe on a failed
+// request, for which it is -1.
+func (r *Response) ContentLength() int64 {
+	if r.Response == nil || r.Response == &r.placeholder || r.err != nil {
+		return -1
+	}
+	if r.Response.ContentLength > 0 {
+		return r.Response.ContentLength
+	}
+	if n, err := strconv.ParseInt(r.Response.Header.Get("Content-Length"), 10, 64); err == nil {
+		return n
+	}
+	return r.Response.ContentLength
+}
+
+// LastModified returns the parsed Last-Modified header, or the zero time if it is absent or malformed.
+func (r *Response) LastModified() time.Time {
+	if r.Response == nil {
+		return time.Time{}
+	}
+	tm, _ := http.ParseTime(r.Response.Header.Get("Last-Modified"))
+	return tm
+}
+
+// FinalURL returns the URL of the request that produced this response, i.e. the last URL
+// after following redirects. It returns an empty string if it is unknown.
+func (r *Response) FinalURL() string {
+	if r.Response == nil || r.Response.Request == nil || r.Response.Request.URL == nil {
+		return ""
+	}
+	return r.Response.Request.URL.String()
+}
+
+// SaveToFile writes the response body to the file at path, creating parent directories
+// as needed. The body is first written to path+".part", which is synced, closed and renamed
+// to path once complete, so path never holds a partial download. If anything fails, the
+// request context being canceled included, the .part file is removed, or kept for resuming
+// the download when WithKeepPartialDownload is set. A nil body produces an empty file.
+//
+// NOTE: This method consumes the response body and can only be called once.
+func (r *Response) SaveToFile(path string) error {
+	return r.HandleResult(func(res *http.Response) error {
+		keepPartial := r.value != nil && r.value.KeepPartial
+		var body io.Reader
+		if res.Body != nil {
+			body = r.bodyReader()
+		}
+		return writeFile(path, body, keepPartial)
+	})
+}
+
+// partSuffix is appended to the destination path while a file is being written.
+const partSuffix = ".part"
+
+func writeFile(path string, body io.Reader, keepPartial bool) (err error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	part := path + partSuffix
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(part, path)
+		}
+		if err != nil && !keepPartial {
+			os.Remove(part)
+		}
+	}()
+	if body != nil {
+		if _, err = io.Copy(f, body); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
 func buildResponse(ctx context.Context, res *http.Response, err error) *Response {
+	r := responsePool.Get().(*Response)
 	if res == nil {
-		res = &http.Response{}
+		res = &r.placeholder
+		if err == nil {
+			err = ErrNoResponse
+		}
 	}
-	return &Response{ctx: ctx, Response: res, err: err}
+	r.ctx, r.Response, r.err = ctx, res, err
+	return r
+}
+
+// NewResponse returns a successful Response carrying status, header and body, as a request
+// would, e.g. to test response handlers or after hooks in isolation. A nil header is replaced
+// by an empty one. The body can be consumed once, like that of any Response.
+func NewResponse(status int, header http.Header, body []byte) *Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	res := &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	return buildResponse(context.Background(), res, nil)
+}
+
+// Release consumes the body if that was not done yet, then hands r back to a pool that
+// later requests draw their Response from, which saves allocations on hot paths.
+//
+// Calling Release is optional, a response that is never released is simply garbage collected.
+// Once released, r must not be used anymore and must not be released twice: the next request
+// may already own it. This also applies to the embedded *http.Response of a failed request.
+// Copy whatever is needed (status code, headers, error) before calling Release.
+func (r *Response) Release() {
+	r.Error()
+	*r = Response{}
+	responsePool.Put(r)
 }
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ErrSchemaViolation is returned (wrapped) for a JSON document that does not match its schema.
+var ErrSchemaViolation = errors.New("schema violation")
+
+// jsonSchema is the subset of JSON Schema supported by RequestSchemaMiddleware: type, enum,
+// properties, required, additionalProperties (boolean), items, minimum, maximum, minLength,
+// maxLength, pattern, minItems and maxItems. Other keywords are ignored.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"-"`
+	Items                *jsonSchema            `json:"items"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("invalid schema type %s", data)
+	}
+	*t = many
+	return nil
+}
+
+func (s *jsonSchema) UnmarshalJSON(data []byte) error {
+	type plain jsonSchema
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var extra struct {
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+	json.Unmarshal(data, &extra)
+	var allowed bool
+	if json.Unmarshal(extra.AdditionalProperties, &allowed) == nil {
+		s.AdditionalProperties = &allowed
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	return nil
+}
+
+func parseSchema(schema []byte) (*jsonSchema, error) {
+	s := new(jsonSchema)
+	if err := json.Unmarshal(schema, s); err != nil {
+		return nil, fmt.Errorf("invalid json schema: %w", err)
+	}
+	return s, nil
+}
+
+func (s *jsonSchema) validate(v any, path string) error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("%w at %s: %s", ErrSchemaViolation, path, fmt.Sprintf(format, args...))
+	}
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeOf(v))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value not in enum")
+		}
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		for name, child := range val {
+			if prop, ok := s.Properties[name]; ok {
+				if err := prop.validate(child, path+"."+name); err != nil {
+					return err
+				}
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fail("unexpected property %q", name)
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			return fail("expected at least %d items, got %d", *s.MinItems, len(val))
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			return fail("expected at most %d items, got %d", *s.MaxItems, len(val))
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := len([]rune(val))
+		if s.MinLength != nil && n < *s.MinLength {
+			return fail("expected at least %d characters, got %d", *s.MinLength, n)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("expected at most %d characters, got %d", *s.MaxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			return fail("%q does not match %q", val, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			return fail("%v is less than the minimum %v", val, *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			return fail("%v is greater than the maximum %v", val, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func (t schemaTypes) match(v any) bool {
+	actual := jsonTypeOf(v)
+	for _, typ := range t {
+		if typ == actual || (typ == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeOf(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// validateSchema checks the JSON request body against s, a request without body is not checked.
+func validateSchema(s *jsonSchema, req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	data, err := RepeatableReadRequest(req)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	var doc any
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%w: request body is not valid json: %v", ErrSchemaViolation, err)
+	}
+	if err = s.validate(doc, "$"); err != nil {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL, err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build !http_noschema
+
+package http
+
+import (
+	"net/http"
+)
+
+// RequestSchemaMiddleware validates the JSON body of outgoing requests against schema before
+// they are sent, failing invalid ones with an error wrapping ErrSchemaViolation. It supports a
+// practical subset of JSON Schema: type, enum, properties, required, additionalProperties
+// (boolean), items, minimum, maximum, minLength, maxLength, pattern, minItems and maxItems.
+// An invalid schema fails every request.
+//
+// Building with the http_noschema tag turns the middleware into a no-op, so that the check can
+// run in development and staging without costing anything in production.
+func RequestSchemaMiddleware(schema []byte) Middleware {
+	s, err := parseSchema(schema)
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			if err != nil {
+				return nil, err
+			}
+			if err := validateSchema(s, req); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build http_noschema
+
+package http
+
+// RequestSchemaMiddleware is a no-op when building with the http_noschema tag.
+func RequestSchemaMiddleware(schema []byte) Middleware {
+	return func(next Endpoint) Endpoint {
+		return next
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 const anyMethod = "*"
@@ -18,15 +20,58 @@ type Server struct {
 	server   *http.Server
 	mux      *http.ServeMux
 	handlers sync.Map
+	// handler is mux wrapped by the middlewares registered with Use.
+	handler     http.Handler
+	middlewares []ServerMiddleware
+	// proxyProtocol is enabled by the WithProxyProtocol option.
+	proxyProtocol bool
+	// proxyTrusted lists the peers allowed to send a PROXY header.
+	proxyTrusted []*net.IPNet
 }
 
 type ServerOption func(*http.Server)
 
+// ServerMiddleware wraps the handler of every request served by a Server.
+type ServerMiddleware func(http.Handler) http.Handler
+
+// WithReadTimeout bounds the time to read an entire request, body included.
+func WithReadTimeout(d time.Duration) ServerOption {
+	return func(srv *http.Server) { srv.ReadTimeout = d }
+}
+
+// WithReadHeaderTimeout bounds the time to read the request headers, the main defense against slowloris clients.
+func WithReadHeaderTimeout(d time.Duration) ServerOption {
+	return func(srv *http.Server) { srv.ReadHeaderTimeout = d }
+}
+
+// WithWriteTimeout bounds the time from the end of the request header read to the end of the response write.
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(srv *http.Server) { srv.WriteTimeout = d }
+}
+
+// WithIdleTimeout bounds how long a keep-alive connection waits for the next request.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(srv *http.Server) { srv.IdleTimeout = d }
+}
+
+// WithDefaultTimeouts applies conservative timeouts suitable for most API servers:
+// 10s to read headers, 30s to read the request, 60s to write the response and 120s idle.
+// Servers streaming long responses should raise the write timeout with a later WithWriteTimeout.
+func WithDefaultTimeouts() ServerOption {
+	return func(srv *http.Server) {
+		srv.ReadHeaderTimeout = 10 * time.Second
+		srv.ReadTimeout = 30 * time.Second
+		srv.WriteTimeout = 60 * time.Second
+		srv.IdleTimeout = 120 * time.Second
+	}
+}
+
 func NewServer() *Server {
 	s := &Server{
 		mux:    http.NewServeMux(),
 		server: &http.Server{},
 	}
+	s.handler = s.mux
 	return s
 }
 
@@ -50,6 +95,14 @@ func (s *Server) Any(pattern string, h http.HandlerFunc) {
 	s.Handle(anyMethod, pattern, h)
 }
 
+// Methods registers h for each of the given methods, e.g. []string{"GET", "HEAD"}. Other
+// methods are answered with 405 Method Not Allowed, unless registered separately.
+func (s *Server) Methods(methods []string, pattern string, h http.HandlerFunc) {
+	for _, method := range methods {
+		s.Handle(method, pattern, h)
+	}
+}
+
 func (s *Server) ListenAndServe(network, addr string, opts ...ServerOption) error {
 	var ln net.Listener
 	switch network {
@@ -83,14 +136,36 @@ func (s *Server) ListenAndServe(network, addr string, opts ...ServerOption) erro
 	return s.Serve(ln, opts...)
 }
 
+// Serve accepts connections on ln. While the options run, the http.Server's Handler is the
+// Server itself, which lets options that need more than the http.Server reach it.
 func (s *Server) Serve(ln net.Listener, opts ...ServerOption) error {
+	s.server.Handler = s
 	for _, fn := range opts {
 		fn(s.server)
 	}
-	s.server.Handler = s.mux
+	s.server.Handler = s
+	if s.proxyProtocol {
+		ln = proxyListener{Listener: ln, trusted: s.proxyTrusted}
+	}
 	return s.server.Serve(ln)
 }
 
+// ServeHTTP dispatches the request through the middlewares to the registered handlers.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Use appends middlewares that wrap every request; the first one added is the outermost.
+// It should be called before the server starts serving.
+func (s *Server) Use(m ...ServerMiddleware) {
+	s.middlewares = append(s.middlewares, m...)
+	var h http.Handler = s.mux
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		h = s.middlewares[i](h)
+	}
+	s.handler = h
+}
+
 func (s *Server) Close(ctx context.Context) error {
 	return s.server.Shutdown(ctx)
 }
@@ -119,6 +194,25 @@ func (s *Server) makeHandler(hs *sync.Map) http.HandlerFunc {
 			val.(http.HandlerFunc).ServeHTTP(w, r)
 			return
 		}
+		w.Header().Set("Allow", allowedMethods(hs))
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// allowedMethods lists the methods registered in hs for the Allow header, OPTIONS included
+// since it is answered automatically.
+func allowedMethods(hs *sync.Map) string {
+	methods := []string{http.MethodOptions}
+	hs.Range(func(key, _ any) bool {
+		if method := key.(string); method != http.MethodOptions {
+			methods = append(methods, method)
+		}
+		return true
+	})
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// HeaderRequestID is the header used to propagate request IDs.
+const HeaderRequestID = "X-Request-Id"
+
+type serverContextKey int
+
+const (
+	keyServerRequestID serverContextKey = iota
+	keyServerRequestStart
+)
+
+// ServerRequestIDMiddleware stores a request ID and the request start time in the request
+// context, see ServerRequestID and ServerRequestStart. The ID is taken from the incoming
+// X-Request-Id header when present, otherwise a random one is generated; either way it is
+// echoed in the response header.
+func ServerRequestIDMiddleware() ServerMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(HeaderRequestID)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(HeaderRequestID, id)
+			ctx := context.WithValue(r.Context(), keyServerRequestID, id)
+			ctx = context.WithValue(ctx, keyServerRequestStart, time.Now())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ServerRequestID returns the request ID set by ServerRequestIDMiddleware, or "" if there is none.
+func ServerRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(keyServerRequestID).(string)
+	return id
+}
+
+// ServerRequestStart returns the time ServerRequestIDMiddleware started handling the request,
+// or the zero time if the middleware is not installed.
+func ServerRequestStart(r *http.Request) time.Time {
+	tm, _ := r.Context().Value(keyServerRequestStart).(time.Time)
+	return tm
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
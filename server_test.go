@@ -1,9 +1,14 @@
 package http
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
@@ -148,3 +153,310 @@ func TestServer_ListenAndServeUnix(t *testing.T) {
 	s.Close(context.Background())
 	wg.Wait()
 }
+
+func serveOnLocalPort(t *testing.T, s *Server, opts ...ServerOption) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln, opts...)
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return ln.Addr().String()
+}
+
+func TestServer_WithProxyProtocol(t *testing.T) {
+	s := NewServer()
+	s.GET("/ip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})
+	addr := serveOnLocalPort(t, s, WithProxyProtocol("127.0.0.0/8"))
+
+	send := func(addr string, header []byte) string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write(header)
+		conn.Write([]byte("GET /ip HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return resp.Status
+		}
+		return string(data)
+	}
+
+	if got := send(addr, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n")); got != "203.0.113.7:51234" {
+		t.Fatalf("v1: unexpected remote addr %q", got)
+	}
+
+	v2 := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, 0x00, 0x0c)
+	v2 = append(v2, 198, 51, 100, 9, 10, 0, 0, 1, 0xc3, 0x50, 0x00, 0x50)
+	if got := send(addr, v2); got != "198.51.100.9:50000" {
+		t.Fatalf("v2: unexpected remote addr %q", got)
+	}
+
+	if got := send(addr, nil); !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Fatalf("no header: unexpected remote addr %q", got)
+	}
+
+	/* a header from a peer outside the trusted CIDRs is not honored */
+	untrusted := NewServer()
+	untrusted.GET("/ip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})
+	addr = serveOnLocalPort(t, untrusted, WithProxyProtocol("10.0.0.0/8", "not-a-cidr"))
+	if got := send(addr, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n")); strings.HasPrefix(got, "203.0.113.7") {
+		t.Fatalf("untrusted peer spoofed its remote addr %q", got)
+	}
+	if got := send(addr, nil); !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Fatalf("untrusted no header: unexpected remote addr %q", got)
+	}
+}
+
+func TestServer_TimeoutOptions(t *testing.T) {
+	s := NewServer()
+	s.GET("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	opts := []ServerOption{WithDefaultTimeouts(), WithReadHeaderTimeout(50 * time.Millisecond), WithWriteTimeout(time.Minute)}
+
+	/* later options override earlier ones, checked on a server that is not serving */
+	srv := &http.Server{}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if srv.ReadHeaderTimeout != 50*time.Millisecond || srv.WriteTimeout != time.Minute ||
+		srv.ReadTimeout != 30*time.Second || srv.IdleTimeout != 120*time.Second {
+		t.Fatalf("unexpected timeouts %v %v %v %v", srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	addr := serveOnLocalPort(t, s, opts...)
+	time.Sleep(10 * time.Millisecond)
+
+	/* a client that never finishes its headers is disconnected */
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ok HTTP/1.1\r\nHost: test\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("expected server to close the slow connection, got %v", err)
+	}
+}
+
+func TestServer_RequestIDMiddleware(t *testing.T) {
+	s := NewServer()
+	var order []string
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "outer")
+			next.ServeHTTP(w, r)
+		})
+	}, ServerRequestIDMiddleware())
+	s.GET("/id", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+		if ServerRequestStart(r).IsZero() {
+			t.Error("expected request start time to be set")
+		}
+		w.Write([]byte(ServerRequestID(r)))
+	})
+	addr := serveOnLocalPort(t, s)
+	client := NewClient()
+
+	res := client.Get(context.Background(), "http://"+addr+"/id", WithHeader(HeaderRequestID, "abc"))
+	body, err := res.GetBody()
+	if err != nil || string(body) != "abc" || res.Header().Get(HeaderRequestID) != "abc" {
+		t.Fatalf("expected incoming request id to be reused, got %q %q %v", body, res.Header().Get(HeaderRequestID), err)
+	}
+
+	res = client.Get(context.Background(), "http://"+addr+"/id")
+	body, err = res.GetBody()
+	if err != nil || len(body) != 32 || res.Header().Get(HeaderRequestID) != string(body) {
+		t.Fatalf("expected a generated request id echoed in the header, got %q %q %v", body, res.Header().Get(HeaderRequestID), err)
+	}
+	if strings.Join(order, ",") != "outer,handler,outer,handler" {
+		t.Fatalf("unexpected middleware order %v", order)
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	if ServerRequestID(req) != "" || !ServerRequestStart(req).IsZero() {
+		t.Fatal("expected empty values without the middleware")
+	}
+}
+
+func TestRespond(t *testing.T) {
+	type payload struct {
+		XMLName struct{} `json:"-" xml:"payload"`
+		Name    string   `json:"name" xml:"name"`
+	}
+	cases := []struct {
+		accept, contentType, body string
+	}{
+		{"", "application/json; charset=utf-8", `{"name":"a"}`},
+		{"*/*", "application/json; charset=utf-8", `{"name":"a"}`},
+		{"application/xml", "application/xml; charset=utf-8", `<payload><name>a</name></payload>`},
+		{"application/json;q=0.5, text/xml", "text/xml; charset=utf-8", `<payload><name>a</name></payload>`},
+		{"text/html", "application/json; charset=utf-8", `{"name":"a"}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		if err := Respond(w, req, payload{Name: "a"}, RespondStatus(http.StatusCreated)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != c.contentType || w.Body.String() != c.body {
+			t.Errorf("Accept %q: got %d %q %q", c.accept, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	RespondError(w, req, http.StatusNotFound, errors.New("no such user"))
+	if w.Code != http.StatusNotFound || w.Body.String() != `{"error":{"code":404,"message":"no such user"}}` {
+		t.Errorf("unexpected JSON error envelope %d %q", w.Code, w.Body.String())
+	}
+	req.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	RespondError(w, req, http.StatusBadRequest, nil)
+	if w.Body.String() != `<error><code>400</code><message>Bad Request</message></error>` {
+		t.Errorf("unexpected XML error envelope %q", w.Body.String())
+	}
+}
+
+type bindPayload struct {
+	Name string `json:"name"`
+}
+
+func (p *bindPayload) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func TestBind(t *testing.T) {
+	cases := []struct {
+		contentType, body string
+		status            int
+	}{
+		{"application/json", `{"name":"a"}`, 0},
+		{"", `{"name":"a"}`, 0},
+		{"application/merge-patch+json", `{"name":"a"}`, 0},
+		{"text/plain", `{"name":"a"}`, http.StatusUnsupportedMediaType},
+		{"application/json", `{"name":`, http.StatusBadRequest},
+		{"application/json", ``, http.StatusBadRequest},
+		{"application/json", `{"name":""}`, http.StatusUnprocessableEntity},
+		{"application/json", `{"name":"` + strings.Repeat("a", int(BindBodyLimit)) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		var p bindPayload
+		err := Bind(req, &p)
+		var bindErr *BindError
+		switch {
+		case c.status == 0 && (err != nil || p.Name != "a"):
+			t.Errorf("%q %q: expected success, got %v", c.contentType, c.body, err)
+		case c.status != 0 && (!errors.As(err, &bindErr) || bindErr.Status != c.status):
+			t.Errorf("%q %.20q: expected status %d, got %v", c.contentType, c.body, c.status, err)
+		}
+	}
+}
+
+func TestServer_EnableCORS(t *testing.T) {
+	s := NewServer()
+	s.EnableCORS(CORSConfig{
+		AllowOrigins:     []string{"https://app.example.com"},
+		ExposeHeaders:    []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	s.GET("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("items"))
+	})
+	serve := func(method, origin string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/items", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "Content-Type",
+	})
+	h := w.Header()
+	if w.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		h.Get("Access-Control-Max-Age") != "600" || !strings.Contains(h.Get("Access-Control-Allow-Methods"), "POST") {
+		t.Fatalf("unexpected preflight response %d %v", w.Code, h)
+	}
+
+	w = serve("GET", "https://app.example.com", nil)
+	if w.Body.String() != "items" || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Access-Control-Expose-Headers") != "X-Total" {
+		t.Fatalf("unexpected actual response %q %v", w.Body.String(), w.Header())
+	}
+
+	if w = serve("OPTIONS", "https://evil.example.com", map[string]string{"Access-Control-Request-Method": "GET"}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a forbidden preflight for an unknown origin, got %d", w.Code)
+	}
+	if w = serve("GET", "", nil); w.Body.String() != "items" || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected same-origin requests untouched, got %v", w.Header())
+	}
+}
+
+func TestServer_Methods(t *testing.T) {
+	s := NewServer()
+	s.Methods([]string{"GET", "head"}, "/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	for _, method := range []string{"GET", "HEAD"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, "/status", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %s to be served, got %d", method, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected POST to be rejected, got %d", w.Code)
+	}
+}
+
+func TestServer_AllowHeader(t *testing.T) {
+	s := NewServer()
+	s.Methods([]string{"GET", "POST"}, "/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("DELETE", "/items", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, OPTIONS, POST" {
+		t.Fatalf("unexpected 405 response %d %v", w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/items", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, OPTIONS, POST" {
+		t.Fatalf("unexpected OPTIONS response %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// SizeObserverMiddleware reports the size of each request and response body to fn, e.g. to
+// feed a histogram of payload sizes per endpoint. Bytes are counted as they flow through the
+// bodies, nothing is buffered. fn is called once the response body is closed (consuming the
+// Response does that), or right away when the request fails. A retried request body is
+// counted once.
+func SizeObserverMiddleware(fn func(reqBytes, respBytes int64)) Middleware {
+	return func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			var reqBody *countingBody
+			if req.Body != nil && req.Body != http.NoBody {
+				reqBody = &countingBody{ReadCloser: req.Body}
+				if isRewindable(req.Body) {
+					req.Body = &rewindableCountingBody{reqBody}
+				} else {
+					req.Body = reqBody
+				}
+			}
+			res, err := next(req)
+			if err != nil || res == nil || res.Body == nil {
+				fn(reqBody.size(), 0)
+				return res, err
+			}
+			resBody := &countingBody{ReadCloser: res.Body}
+			resBody.onClose = func() { fn(reqBody.size(), resBody.size()) }
+			res.Body = resBody
+			return res, err
+		}
+	}
+}
+
+// countingBody counts the bytes read from a body. After a rewind it keeps the furthest position
+// reached, so that a body sent several times is counted once.
+type countingBody struct {
+	io.ReadCloser
+	pos     int64
+	max     atomic.Int64 // read concurrently with the transport writing the request body
+	onClose func()
+	once    sync.Once
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.pos += int64(n)
+	if c.pos > c.max.Load() {
+		c.max.Store(c.pos)
+	}
+	return n, err
+}
+
+func (c *countingBody) Close() error {
+	err := c.ReadCloser.Close()
+	if c.onClose != nil {
+		c.once.Do(c.onClose)
+	}
+	return err
+}
+
+// size returns the bytes counted so far, 0 for a nil body.
+func (c *countingBody) size() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.max.Load()
+}
+
+// rewindableCountingBody is a countingBody over a body that can be rewound, it stays rewindable.
+type rewindableCountingBody struct {
+	*countingBody
+}
+
+func (c *rewindableCountingBody) SeekStart() error {
+	c.pos = 0
+	return c.ReadCloser.(rewinder).SeekStart()
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidURL is wrapped by the errors WithStrictURL returns for rejected URLs.
+var ErrInvalidURL = errors.New("invalid url")
+
+// WithStrictURL validates the request URL before it is sent: the URL must have a scheme and a
+// host, and must not carry userinfo. International domain names are mapped and converted to
+// their ASCII (punycode) form per IDNA, e.g. "Bücher.example" becomes "xn--bcher-kva.example".
+func WithStrictURL() Option {
+	return WithMiddleware(func(next Endpoint) Endpoint {
+		return func(req *http.Request) (*http.Response, error) {
+			if err := normalizeURL(req); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	})
+}
+
+func normalizeURL(req *http.Request) error {
+	u := req.URL
+	switch {
+	case u.Scheme == "":
+		return fmt.Errorf("%w %q: missing scheme", ErrInvalidURL, u.String())
+	case u.User != nil:
+		return fmt.Errorf("%w %q: unexpected userinfo", ErrInvalidURL, u.Redacted())
+	case u.Hostname() == "":
+		return fmt.Errorf("%w %q: missing host", ErrInvalidURL, u.String())
+	}
+	host, err := toASCIIHost(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidURL, u.String(), err)
+	}
+	if host == u.Hostname() {
+		return nil
+	}
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	if req.Host == "" || req.Host == u.Host {
+		req.Host = host
+	}
+	u.Host = host
+	return nil
+}
+
+// toASCIIHost converts an international host name to its ASCII form with the IDNA lookup
+// profile (UTS #46 mapping and validation), as browsers and resolvers do. ASCII hosts, IP
+// literals included, are left as they are.
+func toASCIIHost(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}